@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backups keeps copies of window bodies from before acmego edits
+// them, so that what a broken formatter destroys can be recovered.
+// The copies of a file are named after its path, with the slashes
+// turned into percent signs, and the time of the copy; only the
+// newest keep of them are kept.
+type backups struct {
+	dir  string
+	keep int
+}
+
+// backupTime is the layout of the time in the names of backups.
+const backupTime = "20060102T150405.000000000"
+
+// save writes body, the contents of the window of file before it is
+// formatted, to a new backup, and removes the oldest backups of file
+// past the newest b.keep.
+func (b *backups) save(file string, body []byte) {
+	if b.dir == "" {
+		return
+	}
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		log.Print(err)
+		return
+	}
+	prefix := strings.Replace(file, string(filepath.Separator), "%", -1) + "."
+	name := filepath.Join(b.dir, prefix+time.Now().Format(backupTime)+".bak")
+	if err := ioutil.WriteFile(name, body, 0600); err != nil {
+		log.Print(err)
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(b.dir, globEscape(prefix)+"*.bak"))
+	if err != nil {
+		return
+	}
+	// The backups of files whose names start with that of file, such
+	// as file.orig, match too; only those named with a bare time after
+	// the prefix are of file.
+	var old []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix), ".bak")
+		if _, err := time.Parse(backupTime, stamp); err == nil {
+			old = append(old, m)
+		}
+	}
+	// The times in the names sort in the order of the copies.
+	sort.Strings(old)
+	for len(old) > b.keep && b.keep > 0 {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+}
+
+// prune removes the backups older than age.
+func (b *backups) prune(age time.Duration) {
+	fis, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), ".bak") && time.Since(fi.ModTime()) > age {
+			os.Remove(filepath.Join(b.dir, fi.Name()))
+		}
+	}
+}
+
+// pruneEvery prunes the backups older than age every interval.
+func (b *backups) pruneEvery(interval, age time.Duration) {
+	if b.dir == "" || age <= 0 {
+		return
+	}
+	go func() {
+		for {
+			b.prune(age)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// globEscape quotes the characters of s that filepath.Match treats
+// specially.
+func globEscape(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tagRE matches the build tags in a //go:build expression or a
+// // +build line, with the ! of negated ones.
+var tagRE = regexp.MustCompile(`!?[\pL\pN_.]+`)
+
+// fileTags returns, as a comma-separated list, the build tags the
+// build constraints of the Go file require: those named in its
+// //go:build or // +build lines and not negated. Passing them to
+// goimports lets it resolve the imports of a file that the default
+// build would leave out, such as one for another operating system.
+func fileTags(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var tags []string
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		var expr string
+		switch {
+		case strings.HasPrefix(line, "//go:build "):
+			expr = line[len("//go:build "):]
+		case strings.HasPrefix(line, "// +build "):
+			expr = line[len("// +build "):]
+		case line == "", strings.HasPrefix(line, "//"):
+			continue
+		default:
+			// Build constraints must come before the package clause.
+			return strings.Join(tags, ",")
+		}
+		for _, tag := range tagRE.FindAllString(expr, -1) {
+			if tag[0] != '!' && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		if strings.HasPrefix(line, "//go:build ") {
+			// It supersedes any // +build lines.
+			break
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// tagsEnv returns the environment for a go command, or a tool built on
+// it such as goimports, that builds with the comma-separated tags.
+// GOFLAGS is the only way to give them to goimports. The result is nil,
+// meaning the environment of acmego, if there are no tags.
+func tagsEnv(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	goflags := strings.TrimSpace(os.Getenv("GOFLAGS") + " -tags=" + tags)
+	return append(os.Environ(), "GOFLAGS="+goflags)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+// TrimFmt cleans up the white space of files in no particular
+// language, in-process: with space set it removes the blanks and tabs
+// at the ends of lines, and with newline set it ends the file with
+// exactly one newline, adding it or dropping the empty lines after
+// it. Line endings are kept, LF or CR LF, and a file that is empty or
+// all empty lines is left alone.
+type TrimFmt struct {
+	space   bool
+	newline bool
+}
+
+func (tr *TrimFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return tr.trim(src), nil
+}
+
+func (tr *TrimFmt) trim(src []byte) []byte {
+	if tr.space {
+		src = trimSpace(src)
+	}
+	if tr.newline {
+		src = finalNewline(src)
+	}
+	return src
+}
+
+// trimSpace returns src with the blanks and tabs at the ends of its
+// lines removed. Lines ending in CR LF keep both.
+func trimSpace(src []byte) []byte {
+	new := make([]byte, 0, len(src))
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		end := len(line)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			end -= 2
+		} else if bytes.HasSuffix(line, []byte("\n")) {
+			end--
+		}
+		new = append(new, bytes.TrimRight(line[:end], " \t")...)
+		new = append(new, line[end:]...)
+	}
+	return new
+}
+
+// finalNewline returns src ending in exactly one newline, a CR LF if
+// its last line break is one.
+func finalNewline(src []byte) []byte {
+	text := bytes.TrimRight(src, "\r\n")
+	if len(text) == 0 {
+		return src
+	}
+	eol := "\n"
+	if bytes.HasSuffix(src, []byte("\r\n")) || !bytes.HasSuffix(src, []byte("\n")) && bytes.Contains(src, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	return append(text[:len(text):len(text)], eol...)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimSpace(t *testing.T) {
+	for _, test := range []struct{ src, out string }{
+		{"", ""},
+		{"a \nb\t\n", "a\nb\n"},
+		{"a  \r\nb\r\n", "a\r\nb\r\n"},
+		{"a\n \n", "a\n\n"},
+		{"a \t", "a"},
+	} {
+		if out := string(trimSpace([]byte(test.src))); out != test.out {
+			t.Errorf("trimSpace(%q) = %q, want %q", test.src, out, test.out)
+		}
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	for _, test := range []struct{ src, out string }{
+		{"", ""},
+		{"\n\n", "\n\n"},
+		{"a", "a\n"},
+		{"a\n", "a\n"},
+		{"a\n\n\n", "a\n"},
+		{"a\r\n\r\n", "a\r\n"},
+		{"a\r\nb", "a\r\nb\r\n"},
+	} {
+		if out := string(finalNewline([]byte(test.src))); out != test.out {
+			t.Errorf("finalNewline(%q) = %q, want %q", test.src, out, test.out)
+		}
+	}
+}
+
+func TestChainFmt(t *testing.T) {
+	name := writeTemp(t, "a  \nb")
+	defer os.RemoveAll(filepath.Dir(name))
+	ch := newAnyextFmt("trim, eol")
+	out, err := ch.format(name)
+	if err != nil || string(out) != "a\nb\n" {
+		t.Errorf("format = %q, %v, want %q", out, err, "a\nb\n")
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(name)); len(files) != 1 {
+		t.Errorf("%d files left in the directory, want 1", len(files))
+	}
+	out, err = newAnyextFmt("").format(name)
+	if err != nil || string(out) != "a  \nb" {
+		t.Errorf("empty chain: format = %q, %v, want the file unchanged", out, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// A fmtConfig is a command of the formatters file, given the file name
+// after args and printing the file formatted.
+type fmtConfig struct {
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args"`
+}
+
+// loadFmtConfig reads the formatters file, a JSON object mapping file
+// extensions, or "anyext" for the files with no formatter of their own,
+// to the commands that format them, such as
+//
+//	{
+//		"py": {"cmd": "black", "args": ["-q"]},
+//		"go": ["goimports", "aeol"],
+//		"anyext": "aeol"
+//	}
+//
+// and returns the arguments of the commands of each extension. A
+// command is an object or a string, split at white space into the
+// command and its arguments; a list of them is a pipeline, each
+// command formatting the output of the one before. A missing file is
+// no error and gives no commands. Extensions with a command not found
+// on the PATH are left out with a warning, so that the built-in
+// formatter stays in use rather than failing at every write.
+func loadFmtConfig(file string) (map[string][][]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var exts []string
+	for ext := range config {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	cmds := make(map[string][][]string)
+Exts:
+	for _, ext := range exts {
+		stages, err := parseFmtStages(config[ext])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", file, ext, err)
+		}
+		for _, args := range stages {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				log.Printf("%s: %s: %v; keeping the built-in formatter", file, ext, err)
+				continue Exts
+			}
+		}
+		cmds[strings.TrimPrefix(ext, ".")] = stages
+	}
+	return cmds, nil
+}
+
+// parseFmtStages returns the arguments of the commands of msg, an entry
+// of the formatters file: a command, or a list of them.
+func parseFmtStages(msg json.RawMessage) ([][]string, error) {
+	list := []json.RawMessage{msg}
+	if bytes.HasPrefix(bytes.TrimSpace(msg), []byte("[")) {
+		if err := json.Unmarshal(msg, &list); err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no commands")
+		}
+	}
+	var stages [][]string
+	for _, m := range list {
+		var args []string
+		var s string
+		if err := json.Unmarshal(m, &s); err == nil {
+			args = strings.Fields(s)
+		} else {
+			var c fmtConfig
+			d := json.NewDecoder(bytes.NewReader(m))
+			d.DisallowUnknownFields()
+			if err := d.Decode(&c); err != nil {
+				return nil, err
+			}
+			if c.Cmd != "" {
+				args = append([]string{c.Cmd}, c.Args...)
+			}
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no cmd")
+		}
+		stages = append(stages, args)
+	}
+	return stages, nil
+}
+
+// configFmts replaces the formatters in fmts with the commands of the
+// formatters file, if there is one, a pipeline of several commands
+// being a ChainFmt.
+func configFmts(fmts map[string]Formatter, file string) {
+	if file == "" {
+		return
+	}
+	cmds, err := loadFmtConfig(file)
+	if err != nil {
+		log.Printf("%v; using the built-in formatters", err)
+		return
+	}
+	for ext, stages := range cmds {
+		if len(stages) == 1 {
+			fmts[ext] = &CmdFmt{stages[0]}
+			continue
+		}
+		ch := new(ChainFmt)
+		for _, args := range stages {
+			ch.fmts = append(ch.fmts, &CmdFmt{args})
+		}
+		fmts[ext] = ch
+	}
+}
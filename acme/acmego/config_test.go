@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadFmtConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "formatters.json")
+	if cmds, err := loadFmtConfig(file); err != nil || cmds != nil {
+		t.Errorf("missing file: %q, %v, want none", cmds, err)
+	}
+
+	config := `{
+		"py": {"cmd": "sed", "args": ["-e", "s/a/b/"]},
+		"go": ["sed s/a/b/", {"cmd": "sed", "args": ["s/b/c/"]}],
+		".anyext": "cat",
+		"rs": {"cmd": "acmego-no-such-command"},
+		"kt": ["cat", "acmego-no-such-command"]
+	}`
+	if err := ioutil.WriteFile(file, []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := loadFmtConfig(file)
+	want := map[string][][]string{
+		"py":     {{"sed", "-e", "s/a/b/"}},
+		"go":     {{"sed", "s/a/b/"}, {"sed", "s/b/c/"}},
+		"anyext": {{"cat"}},
+	}
+	if err != nil || !reflect.DeepEqual(cmds, want) {
+		t.Errorf("loadFmtConfig = %q, %v, want %q", cmds, err, want)
+	}
+	fmts := map[string]Formatter{"py": &PyFmt{cmd: "yapf"}, "rs": &RustFmt{cmd: "fmtrust"}}
+	configFmts(fmts, file)
+	if _, ok := fmts["py"].(*CmdFmt); !ok {
+		t.Errorf("py formatter is %T, want *CmdFmt", fmts["py"])
+	}
+	if _, ok := fmts["rs"].(*RustFmt); !ok {
+		t.Errorf("rs formatter with a missing command is %T, want the built-in *RustFmt", fmts["rs"])
+	}
+	src := filepath.Join(dir, "x.go")
+	if err := ioutil.WriteFile(src, []byte("a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if new, err := fmts["go"].format(src); err != nil || string(new) != "c\n" {
+		t.Errorf("go pipeline gives %q, %v, want %q", new, err, "c\n")
+	}
+
+	for _, config := range []string{
+		`{"py": 1}`,
+		`{"py": {"command": "black"}}`,
+		`{"py": {"args": ["-q"]}}`,
+		`{"py": {"cmd": "cat"}`,
+		`{"py": []}`,
+		`{"py": ["cat", ""]}`,
+		`{"py": [["cat"]]}`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(config), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if cmds, err := loadFmtConfig(file); err == nil {
+			t.Errorf("loadFmtConfig(%s) = %q, want error", config, cmds)
+		}
+	}
+}
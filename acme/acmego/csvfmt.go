@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// CsvFmt tidies comma or tab separated files. The records are written
+// back one to a line, with only the quotes they need, so the result
+// reads back as the same records. The columns are not aligned then:
+// padding a field would change it for any reader. If plain is set
+// they are, for reading the file as text: every field but the last in
+// a row is padded with blanks before its separator to the width of
+// its column, and fields are left unquoted.
+// The formatter is implemented in-process; it aborts with an error
+// on malformed input, such as rows with differing field counts.
+type CsvFmt struct {
+	comma rune
+	plain bool
+}
+
+func (c *CsvFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	new, err := c.align(src)
+	if err != nil {
+		fmtError(file, "csvfmt", err, nil)
+	}
+	return new, err
+}
+
+func (c *CsvFmt) align(src []byte) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(src))
+	r.Comma = c.comma
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.plain {
+		var buf bytes.Buffer
+		for _, rec := range records {
+			if len(rec) == 1 && rec[0] == "" {
+				// An empty line is no record at all.
+				buf.WriteString(`""` + "\n")
+				continue
+			}
+			for i, field := range rec {
+				if i > 0 {
+					buf.WriteRune(c.comma)
+				}
+				buf.WriteString(c.quote(field))
+			}
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+
+	var widths []int
+	for _, rec := range records {
+		for i, field := range rec {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(field); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	var buf bytes.Buffer
+	for _, rec := range records {
+		for i, field := range rec {
+			buf.WriteString(field)
+			if i == len(rec)-1 {
+				break
+			}
+			buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(field)))
+			buf.WriteRune(c.comma)
+			if c.comma != '\t' {
+				buf.WriteByte(' ')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// quote returns field as it must appear in the output to read back
+// as the same value.
+func (c *CsvFmt) quote(field string) string {
+	if !strings.ContainsAny(field, string(c.comma)+"\"\r\n") {
+		return field
+	}
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+var csvTests = []struct {
+	name  string
+	comma rune
+	src   string
+}{
+	{"plain", ',', "name,age\nalice,30\nbob,4\n"},
+	{"leading blanks", ',', "a, b,c\n  x,y ,z\n"},
+	{"quotes", ',', "\"a,b\",\"say \"\"hi\"\"\",\"two\nlines\"\n\"\",x,\"y\"\n"},
+	{"empty record", ',', "a\n\"\"\nb\n"},
+	{"tsv", '\t', "a\t b\tc\nlong field\tx\t\"q\"\"\"\n"},
+}
+
+// TestCsvFmtRecords checks that the output reads back as the records
+// of the input, blanks and all.
+func TestCsvFmtRecords(t *testing.T) {
+	read := func(comma rune, text []byte) ([][]string, error) {
+		r := csv.NewReader(bytes.NewReader(text))
+		r.Comma = comma
+		return r.ReadAll()
+	}
+	for _, test := range csvTests {
+		want, err := read(test.comma, []byte(test.src))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		out, err := (&CsvFmt{comma: test.comma}).align([]byte(test.src))
+		if err != nil {
+			t.Errorf("%s: align: %v", test.name, err)
+			continue
+		}
+		got, err := read(test.comma, out)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: align = %q, which reads as %q, %v, want %q", test.name, out, got, err, want)
+		}
+	}
+}
+
+func TestCsvFmtPlain(t *testing.T) {
+	out, err := (&CsvFmt{comma: ',', plain: true}).align([]byte("name,age\nalice,30\nbob,4\n"))
+	want := "name , age\nalice, 30\nbob  , 4\n"
+	if err != nil || string(out) != want {
+		t.Errorf("align = %q, %v, want %q", out, err, want)
+	}
+	out, err = (&CsvFmt{comma: '\t', plain: true}).align([]byte("name\tage\nalice\t30\n"))
+	want = "name \tage\nalice\t30\n"
+	if err != nil || string(out) != want {
+		t.Errorf("tsv: align = %q, %v, want %q", out, err, want)
+	}
+}
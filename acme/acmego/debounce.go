@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"9fans.net/go/acme"
+)
+
+// A logReader reads the events of the acme log, as *acme.LogReader.
+type logReader interface {
+	Read() (acme.LogEvent, error)
+}
+
+// readLog sends the events read from l to events until reading fails,
+// as when acme is gone, and returns the error.
+func readLog(l logReader, events chan<- acme.LogEvent) error {
+	for {
+		event, err := l.Read()
+		if err != nil {
+			return err
+		}
+		events <- event
+	}
+}
+
+// debounceEvents passes on the events from in, except that those for
+// which hold is true, the writes that trigger formatting, are held for
+// delay. Further such events of the same window within that time
+// replace the one held, so that a burst of Puts, as from a script, is
+// formatted once, with the latest of them, instead of once for each,
+// with the formats racing against the writes that follow. Bursts keep
+// to the delay from their first event, so that a window written to
+// all the time is still formatted. The events of other windows are
+// never held up by a burst. Once in is closed the events held are
+// passed on, and the channel returned is closed.
+func debounceEvents(in <-chan acme.LogEvent, delay time.Duration, hold func(acme.LogEvent) bool) <-chan acme.LogEvent {
+	if delay <= 0 {
+		return in
+	}
+	out := make(chan acme.LogEvent)
+	go func() {
+		defer close(out)
+		pending := make(map[int]*heldEvent) // by window
+		for {
+			var wait <-chan time.Time
+			if next := earliest(pending); next != nil {
+				wait = time.After(time.Until(next.due))
+			}
+			select {
+			case event, ok := <-in:
+				if !ok {
+					for next := earliest(pending); next != nil; next = earliest(pending) {
+						delete(pending, next.ID)
+						out <- next.LogEvent
+					}
+					return
+				}
+				if !hold(event) {
+					out <- event
+					continue
+				}
+				if h := pending[event.ID]; h != nil {
+					h.LogEvent = event
+					continue
+				}
+				pending[event.ID] = &heldEvent{event, time.Now().Add(delay)}
+			case now := <-wait:
+				var due []*heldEvent
+				for _, h := range pending {
+					if !h.due.After(now) {
+						due = append(due, h)
+					}
+				}
+				sort.Slice(due, func(i, j int) bool { return due[i].due.Before(due[j].due) })
+				for _, h := range due {
+					delete(pending, h.ID)
+					out <- h.LogEvent
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// A heldEvent is the latest event of a burst, to be passed on when the
+// burst is due.
+type heldEvent struct {
+	acme.LogEvent
+	due time.Time
+}
+
+// earliest returns the held event due first, or nil if there is none.
+func earliest(pending map[int]*heldEvent) *heldEvent {
+	var next *heldEvent
+	for _, h := range pending {
+		if next == nil || h.due.Before(next.due) || h.due.Equal(next.due) && h.ID < next.ID {
+			next = h
+		}
+	}
+	return next
+}
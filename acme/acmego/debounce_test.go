@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"9fans.net/go/acme"
+)
+
+// fakeLog is a logReader that reads canned events, and then io.EOF.
+type fakeLog struct {
+	events []acme.LogEvent
+}
+
+func (l *fakeLog) Read() (acme.LogEvent, error) {
+	if len(l.events) == 0 {
+		return acme.LogEvent{}, io.EOF
+	}
+	event := l.events[0]
+	l.events = l.events[1:]
+	return event, nil
+}
+
+func TestDebounceEvents(t *testing.T) {
+	l := &fakeLog{}
+	for i := 0; i < 10; i++ {
+		l.events = append(l.events, acme.LogEvent{ID: 1, Op: "put", Name: "/a/x.go"})
+	}
+	l.events = append(l.events,
+		acme.LogEvent{ID: 2, Op: "put", Name: "/a/y.go"},
+		acme.LogEvent{ID: 1, Op: "focus", Name: "/a/x.go"},
+		acme.LogEvent{ID: 1, Op: "put", Name: "/a/z.go"}, // renamed; the latest counts
+	)
+	logged := make(chan acme.LogEvent)
+	go func() {
+		if err := readLog(l, logged); err != io.EOF {
+			t.Errorf("readLog = %v, want io.EOF", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(logged)
+	}()
+	events := debounceEvents(logged, 20*time.Millisecond, func(event acme.LogEvent) bool {
+		return event.Op == "put"
+	})
+	var formatted []acme.LogEvent
+	reformatted := make(map[int]int)
+	for event := range events {
+		if event.Op == "put" {
+			reformatted[event.ID]++
+		}
+		formatted = append(formatted, event)
+	}
+	want := []acme.LogEvent{
+		{ID: 1, Op: "focus", Name: "/a/x.go"},
+		{ID: 1, Op: "put", Name: "/a/z.go"},
+		{ID: 2, Op: "put", Name: "/a/y.go"},
+	}
+	if !reflect.DeepEqual(formatted, want) {
+		t.Errorf("events = %v, want %v", formatted, want)
+	}
+	if reformatted[1] != 1 || reformatted[2] != 1 {
+		t.Errorf("formats by window = %v, want one for each", reformatted)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runDiff returns the differences between the contents of file and
+// new in the default output format of diff(1). It is a variable so
+// that tests can substitute canned output.
+var runDiff = diffFile
+
+// diffFile compares file with new in process, with no need for a diff
+// command on the system.
+func diffFile(file string, new []byte) ([]byte, error) {
+	old, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return edDiff(old, new), nil
+}
+
+// An edit is a change to a window body: the text at addr is
+// replaced with data. Start and end are the first and last lines of
+// the original text next to or inside the change; end is zero if the
+// edit is not tied to particular lines.
+type edit struct {
+	addr       string
+	data       []byte
+	start, end int
+}
+
+// diffEdits returns the edits that turn old, the contents of file,
+// into new. If ignoreSpace is set, changes that only touch trailing
+// white space are left out.
+func diffEdits(file string, old, new []byte, ignoreSpace bool) ([]edit, error) {
+	diff, err := runDiff(file, new)
+	if err != nil {
+		return nil, err
+	}
+	return computeEdits(diff, old, new, ignoreSpace)
+}
+
+// An Edit is a hunk of a diff, in the terms of the default output
+// format of diff(1). Op is 'a' if lines NewStart through NewEnd of the
+// new text are added after line OldStart of the old one, 0 for the
+// start of the text; 'd' if lines OldStart through OldEnd of the old
+// text are deleted, which would come after line NewStart of the new
+// one; and 'c' if lines OldStart through OldEnd are changed into lines
+// NewStart through NewEnd. OldEnd is OldStart for an 'a' and NewEnd is
+// NewStart for a 'd'. OldNoEOL and NewNoEOL report that the hunk takes
+// in the last line of the old or the new text, with no newline after
+// it.
+type Edit struct {
+	Op                 byte
+	OldStart, OldEnd   int
+	NewStart, NewEnd   int
+	OldNoEOL, NewNoEOL bool
+}
+
+// noEOL is the line that diff(1) writes after a last line with no
+// newline.
+const noEOL = `\ No newline at end of file`
+
+// ParseEdDiff parses diff, in the default output format of diff(1),
+// into its hunks, in the order they come. It returns an error for any
+// line that is not part of that format.
+func ParseEdDiff(diff []byte) ([]Edit, error) {
+	var edits []Edit
+	side := byte(0) // '<' or '>', for the text lines of the last hunk
+	for _, line := range strings.Split(string(diff), "\n") {
+		switch {
+		case line == "" || line == "---":
+			continue
+		case line == noEOL:
+			if side == 0 {
+				return nil, fmt.Errorf("cannot parse diff line: %q", line)
+			}
+			if side == '<' {
+				edits[len(edits)-1].OldNoEOL = true
+			} else {
+				edits[len(edits)-1].NewNoEOL = true
+			}
+			continue
+		case line[0] == '<' || line[0] == '>':
+			if len(edits) == 0 {
+				return nil, fmt.Errorf("cannot parse diff line: %q", line)
+			}
+			side = line[0]
+			continue
+		}
+		j := strings.IndexAny(line, "acd")
+		if j < 0 {
+			return nil, fmt.Errorf("cannot parse diff line: %q", line)
+		}
+		oldStart, oldEnd, err := parseSpan(line[:j])
+		if err != nil {
+			return nil, err
+		}
+		newStart, newEnd, err := parseSpan(line[j+1:])
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, Edit{Op: line[j], OldStart: oldStart, OldEnd: oldEnd, NewStart: newStart, NewEnd: newEnd})
+		side = 0
+	}
+	return edits, nil
+}
+
+var hunkRE = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ParseUnifiedDiff parses diff, in the unified format of diff -u, into
+// its hunks as ParseEdDiff returns them, each run of removed and added
+// lines between unchanged ones a hunk of its own. The lines before the
+// first @@ header, and those between files, are skipped.
+func ParseUnifiedDiff(diff []byte) ([]Edit, error) {
+	var edits []Edit
+	var run Edit             // the run of changed lines under way
+	o, n := 0, 0             // the next lines of the old and the new text
+	oldLeft, newLeft := 0, 0 // the lines of each yet to come in the hunk
+	prev := byte(0)          // the kind of the last line of a hunk
+	flush := func() {
+		k, m := run.OldEnd, run.NewEnd // the lines removed and added
+		switch {
+		case k == 0 && m == 0:
+			return
+		case m == 0:
+			run.Op, run.OldStart, run.OldEnd, run.NewStart, run.NewEnd = 'd', o, o+k-1, n-1, n-1
+		case k == 0:
+			run.Op, run.OldStart, run.OldEnd, run.NewStart, run.NewEnd = 'a', o-1, o-1, n, n+m-1
+		default:
+			run.Op, run.OldStart, run.OldEnd, run.NewStart, run.NewEnd = 'c', o, o+k-1, n, n+m-1
+		}
+		edits = append(edits, run)
+		o, n = o+k, n+m
+		run = Edit{}
+	}
+	// An empty line inside a hunk is an unchanged one, but not the
+	// empty string after the final newline.
+	for _, line := range strings.Split(strings.TrimSuffix(string(diff), "\n"), "\n") {
+		if line == noEOL {
+			switch prev {
+			case '-':
+				run.OldNoEOL = true
+			case '+':
+				run.NewNoEOL = true
+			case ' ':
+			default:
+				return nil, fmt.Errorf("cannot parse diff line: %q", line)
+			}
+			continue
+		}
+		if oldLeft == 0 && newLeft == 0 {
+			flush()
+			prev = 0
+			m := hunkRE.FindStringSubmatch(line)
+			if m == nil {
+				if line == "" || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") ||
+					strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "index ") {
+					continue
+				}
+				return nil, fmt.Errorf("cannot parse diff line: %q", line)
+			}
+			o, oldLeft = hunkLines(m[1], m[2])
+			n, newLeft = hunkLines(m[3], m[4])
+			continue
+		}
+		kind := byte(' ')
+		if line != "" {
+			// Some tools trim the lone space of unchanged empty lines.
+			kind = line[0]
+		}
+		switch kind {
+		case ' ':
+			flush()
+			o, n = o+1, n+1
+			oldLeft, newLeft = oldLeft-1, newLeft-1
+		case '-':
+			run.OldEnd++
+			oldLeft--
+		case '+':
+			run.NewEnd++
+			newLeft--
+		default:
+			return nil, fmt.Errorf("cannot parse diff line: %q", line)
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, fmt.Errorf("diff hunk longer than its header: %q", line)
+		}
+		prev = kind
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("diff ends inside a hunk")
+	}
+	flush()
+	return edits, nil
+}
+
+// hunkLines returns the first line of one side of a unified diff hunk,
+// given the start and count of its header, and the number of lines.
+// When there are none the start is the line before the hunk.
+func hunkLines(start, count string) (first, lines int) {
+	first, _ = strconv.Atoi(start)
+	lines = 1
+	if count != "" {
+		lines, _ = strconv.Atoi(count)
+	}
+	if lines == 0 {
+		first++
+	}
+	return first, lines
+}
+
+// computeEdits parses diff, the output of diff(1) run on old and new
+// in either its default format or the unified one, into edits that
+// turn old into new. The edits run from the end of the text to its
+// start, so the line addresses of each one are still valid after
+// applying those before it. If any part of diff cannot be parsed there
+// are no edits, only an error.
+//
+// Acme counts characters in runes, not bytes, so the addresses are
+// made of whole lines only: a line range, the empty string at the end
+// of a line ("n+#0", which moves no characters past it), the start of
+// the body or its end. The data is whole lines of new as well. Neither
+// depends on how many bytes the characters in the text take.
+func computeEdits(diff, old, new []byte, ignoreSpace bool) ([]edit, error) {
+	parse := ParseEdDiff
+	if bytes.HasPrefix(diff, []byte("--- ")) || bytes.HasPrefix(diff, []byte("@@ ")) || bytes.HasPrefix(diff, []byte("diff ")) {
+		parse = ParseUnifiedDiff
+	}
+	hunks, err := parse(diff)
+	if err != nil {
+		return nil, err
+	}
+	var edits []edit
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		if h.OldNoEOL && !h.NewNoEOL {
+			// The hunk's lines of new end in newlines. With one
+			// added at the end of the body first, the last line
+			// of old is whole too.
+			edits = append(edits, edit{addr: "$", data: []byte("\n")})
+		}
+		switch h.Op {
+		case 'a':
+			addr := strconv.Itoa(h.OldStart) + "+#0"
+			if h.OldStart == 0 {
+				// Insertion at the start of the file.
+				addr = "#0"
+			}
+			edits = append(edits, edit{addr, findLines(new, h.NewStart, h.NewEnd), h.OldStart, h.OldStart + 1})
+		case 'c':
+			if ignoreSpace && sameTrimmed(findLines(old, h.OldStart, h.OldEnd), findLines(new, h.NewStart, h.NewEnd)) {
+				continue
+			}
+			edits = append(edits, edit{span(h.OldStart, h.OldEnd), findLines(new, h.NewStart, h.NewEnd), h.OldStart, h.OldEnd})
+		case 'd':
+			edits = append(edits, edit{span(h.OldStart, h.OldEnd), nil, h.OldStart, h.OldEnd})
+		}
+	}
+	return edits, nil
+}
+
+// mergeEdits returns edits, as computeEdits returns them, with the
+// edits that are at most gap unchanged lines of old apart merged into
+// one, which takes in the lines between them as they are. Fewer edits
+// churn the window less, and a formatter that touches every other line
+// makes one edit rather than many. The edit for a missing final
+// newline is never merged.
+func mergeEdits(edits []edit, old []byte, gap int) []edit {
+	if len(edits) < 2 {
+		return edits
+	}
+	var merged []edit
+	cur := edits[0]
+	for _, e := range edits[1:] {
+		// e comes before cur in the text.
+		lo, hi, ok1 := editLines(e)
+		curLo, curHi, ok2 := editLines(cur)
+		if !ok1 || !ok2 || curLo-hi-1 > gap {
+			merged = append(merged, cur)
+			cur = e
+			continue
+		}
+		data := append(append(append([]byte(nil), e.data...), findLines(old, hi+1, curLo-1)...), cur.data...)
+		cur = edit{span(lo, curHi), data, lo, curHi}
+	}
+	return append(merged, cur)
+}
+
+// editLines returns the lines of the original text that e replaces,
+// lo through hi; hi is lo-1 if e only inserts lines. It reports false
+// for edits not tied to lines.
+func editLines(e edit) (lo, hi int, ok bool) {
+	switch {
+	case e.end == 0 || e.addr == "$":
+		return 0, 0, false
+	case e.addr == "#0":
+		return 1, 0, true
+	case strings.HasSuffix(e.addr, "+#0"):
+		return e.start + 1, e.start, true
+	}
+	return e.start, e.end, true
+}
+
+// shiftDot returns where q, a rune offset in old, moves to once edits,
+// as computeEdits returns them, are made to old: past the text that
+// the edits before it add or remove, so that the dot stays on the same
+// text. If q is inside the lines an edit replaces, it moves to the same
+// column of the same line of the replacement, or to the end of the
+// replacement if that has fewer lines, so that the dot stays on a line
+// that only changed its indentation, say, and near it otherwise.
+func shiftDot(old []byte, edits []edit, q int) int {
+	body := []rune(string(old))
+	starts := []int{0}
+	for i, r := range body {
+		if r == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	// lineStart returns the offset of the start of line n, or of the
+	// end of the body if it has fewer lines.
+	lineStart := func(n int) int {
+		if n-1 < len(starts) {
+			return starts[n-1]
+		}
+		return len(body)
+	}
+	pos, shift := q, 0
+	for _, e := range edits {
+		r0, r1 := 0, len(body)
+		if lo, hi, ok := editLines(e); ok {
+			r0, r1 = lineStart(lo), lineStart(hi+1)
+		} else if e.addr == "$" {
+			r0 = r1
+		}
+		data := []rune(string(e.data))
+		switch {
+		case r1 <= q:
+			shift += len(data) - (r1 - r0)
+		case r0 <= q:
+			// Find the line and column of q after r0, then the
+			// same in data.
+			line, col := 0, 0
+			for _, r := range body[r0:q] {
+				col++
+				if r == '\n' {
+					line, col = line+1, 0
+				}
+			}
+			pos = r0 + len(data)
+			for i := 0; i <= len(data); i++ {
+				if line == 0 {
+					end := i
+					for end < len(data) && data[end] != '\n' {
+						end++
+					}
+					if end-i > col {
+						end = i + col
+					}
+					pos = r0 + end
+					break
+				}
+				if i < len(data) && data[i] == '\n' {
+					line--
+				}
+			}
+		}
+	}
+	return pos + shift
+}
+
+// describeEdits returns the edits, as computeEdits returns them, as
+// the commands of diff(1) that make them, in the order of the text:
+// "3a4,5" adds lines 4 and 5 of the new text after line 3 of the old,
+// "2,3d1" deletes lines 2 and 3, and "4c6" changes line 4 into line 6.
+// The edit adding a final newline and one replacing the whole body are
+// described in words.
+func describeEdits(edits []edit) []string {
+	var cmds []string
+	shift := 0 // the lines the edits so far add, less those they delete
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		lo, hi, ok := editLines(e)
+		if !ok {
+			if e.addr == "$" {
+				cmds = append(cmds, "newline at end of file")
+			} else {
+				cmds = append(cmds, "replacement of the whole body")
+			}
+			continue
+		}
+		n := bytes.Count(e.data, []byte("\n"))
+		if len(e.data) > 0 && e.data[len(e.data)-1] != '\n' {
+			n++
+		}
+		newLo := lo + shift
+		switch {
+		case hi < lo:
+			cmds = append(cmds, fmt.Sprintf("%da%s", hi, edSpan(newLo, newLo+n-1)))
+		case n == 0:
+			cmds = append(cmds, fmt.Sprintf("%sd%d", edSpan(lo, hi), newLo-1))
+		default:
+			cmds = append(cmds, fmt.Sprintf("%sc%s", edSpan(lo, hi), edSpan(newLo, newLo+n-1)))
+		}
+		shift += n - (hi - lo + 1)
+	}
+	return cmds
+}
+
+// span returns the acme address of lines start through end.
+func span(start, end int) string {
+	return strconv.Itoa(start) + "," + strconv.Itoa(end)
+}
+
+// parseSpan parses a line range of a diff(1) command, either a
+// single line number or two separated by a comma. Surrounding white
+// space is allowed, as some diff implementations pad the numbers.
+func parseSpan(text string) (start, end int, err error) {
+	i := strings.Index(text, ",")
+	if i < 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot parse span %q", text)
+		}
+		return n, n, nil
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(text[:i]))
+	end, err2 := strconv.Atoi(strings.TrimSpace(text[i+1:]))
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("cannot parse span %q", text)
+	}
+	return start, end, nil
+}
+
+// sameTrimmed reports whether a and b hold the same lines when
+// trailing white space is ignored.
+func sameTrimmed(a, b []byte) bool {
+	al := bytes.Split(a, []byte("\n"))
+	bl := bytes.Split(b, []byte("\n"))
+	if len(al) != len(bl) {
+		return false
+	}
+	for i := range al {
+		if !bytes.Equal(bytes.TrimRight(al[i], " \t\r"), bytes.TrimRight(bl[i], " \t\r")) {
+			return false
+		}
+	}
+	return true
+}
+
+func findLines(text []byte, start, end int) []byte {
+	i := 0
+
+	start--
+	for ; i < len(text) && start > 0; i++ {
+		if text[i] == '\n' {
+			start--
+			end--
+		}
+	}
+	startByte := i
+	for ; i < len(text) && end > 0; i++ {
+		if text[i] == '\n' {
+			end--
+		}
+	}
+	endByte := i
+	return text[startByte:endByte]
+}
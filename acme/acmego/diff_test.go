@@ -0,0 +1,493 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type diffTest struct {
+	name     string
+	old, new string
+	diff     string // canned diff(1) output for old and new
+	unified  string // that of diff -u, if not empty
+	edits    []edit
+}
+
+var diffTests = []diffTest{
+	{
+		name:    "change",
+		old:     "a\nb\nc\n",
+		new:     "a\nB\nc\n",
+		diff:    "2c2\n< b\n---\n> B\n",
+		unified: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		edits:   []edit{{"2,2", []byte("B\n"), 2, 2}},
+	},
+	{
+		name:    "add",
+		old:     "a\nb\n",
+		new:     "a\nx\ny\nb\n",
+		diff:    "1a2,3\n> x\n> y\n",
+		unified: "@@ -1,2 +1,4 @@\n a\n+x\n+y\n b\n",
+		edits:   []edit{{"1+#0", []byte("x\ny\n"), 1, 2}},
+	},
+	{
+		name:    "delete",
+		old:     "a\nb\nc\n",
+		new:     "a\nc\n",
+		diff:    "2d1\n< b\n",
+		unified: "@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		edits:   []edit{{"2,2", nil, 2, 2}},
+	},
+	{
+		name:    "add at start of file",
+		old:     "b\n",
+		new:     "a\nb\n",
+		diff:    "0a1\n> a\n",
+		unified: "@@ -1 +1,2 @@\n+a\n b\n",
+		edits:   []edit{{"#0", []byte("a\n"), 0, 1}},
+	},
+	{
+		name:    "delete at start of file",
+		old:     "a\nb\n",
+		new:     "b\n",
+		diff:    "1d0\n< a\n",
+		unified: "@@ -1,2 +1 @@\n-a\n b\n",
+		edits:   []edit{{"1,1", nil, 1, 1}},
+	},
+	{
+		name:    "several hunks",
+		old:     "a\nb\nc\nd\n",
+		new:     "A\nb\nc\n",
+		diff:    "1c1\n< a\n---\n> A\n4d3\n< d\n",
+		unified: "@@ -1,4 +1,3 @@\n-a\n+A\n b\n c\n-d\n",
+		edits: []edit{
+			{"4,4", nil, 4, 4},
+			{"1,1", []byte("A\n"), 1, 1},
+		},
+	},
+	{
+		name:    "no newline at end of file",
+		old:     "a\nb",
+		new:     "a\nb\n",
+		diff:    "2c2\n< b\n\\ No newline at end of file\n---\n> b\n",
+		unified: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		edits: []edit{
+			{"$", []byte("\n"), 0, 0},
+			{"2,2", []byte("b\n"), 2, 2},
+		},
+	},
+	{
+		name:    "add after multibyte text",
+		old:     "h\u00e9llo\nw\u00f6rld\n",
+		new:     "h\u00e9llo\n// \u65e5\u672c\nw\u00f6rld\n",
+		diff:    "1a2\n> // \u65e5\u672c\n",
+		unified: "@@ -1,2 +1,3 @@\n h\u00e9llo\n+// \u65e5\u672c\n w\u00f6rld\n",
+		edits:   []edit{{"1+#0", []byte("// \u65e5\u672c\n"), 1, 2}},
+	},
+	{
+		name:    "multibyte hunks",
+		old:     "\u03b1\n\u03b2\n\u03b3\n\u03b4\n",
+		new:     "\u03b1\nB\n\u03b3\n",
+		diff:    "2c2\n< \u03b2\n---\n> B\n4d3\n< \u03b4\n",
+		unified: "@@ -1,4 +1,3 @@\n \u03b1\n-\u03b2\n+B\n \u03b3\n-\u03b4\n",
+		edits: []edit{
+			{"4,4", nil, 4, 4},
+			{"2,2", []byte("B\n"), 2, 2},
+		},
+	},
+	{
+		name:    "multibyte last line without newline",
+		old:     "a\n\u00fc",
+		new:     "a\n\u00fc\n",
+		diff:    "2c2\n< \u00fc\n\\ No newline at end of file\n---\n> \u00fc\n",
+		unified: "@@ -2 +2 @@\n-\u00fc\n\\ No newline at end of file\n+\u00fc\n",
+		edits: []edit{
+			{"$", []byte("\n"), 0, 0},
+			{"2,2", []byte("\u00fc\n"), 2, 2},
+		},
+	},
+	{
+		name:    "no newline at end of either",
+		old:     "a\nb\nc",
+		new:     "a\nb\nC",
+		diff:    "3c3\n< c\n\\ No newline at end of file\n---\n> C\n\\ No newline at end of file\n",
+		unified: "@@ -1,3 +1,3 @@\n a\n b\n-c\n\\ No newline at end of file\n+C\n\\ No newline at end of file\n",
+		edits:   []edit{{"3,3", []byte("C"), 3, 3}},
+	},
+	{
+		name:    "newline at end of file removed",
+		old:     "a\nb\n",
+		new:     "a\nB",
+		diff:    "2c2\n< b\n---\n> B\n\\ No newline at end of file\n",
+		unified: "@@ -1,2 +1,2 @@\n a\n-b\n+B\n\\ No newline at end of file\n",
+		edits:   []edit{{"2,2", []byte("B"), 2, 2}},
+	},
+	{
+		name:    "changes at both ends",
+		old:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		new:     "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\nX\n",
+		diff:    "0a1\n> 0\n10c11\n< 10\n---\n> X\n",
+		unified: "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -7,4 +8,4 @@\n 7\n 8\n 9\n-10\n+X\n",
+		edits: []edit{
+			{"10,10", []byte("X\n"), 10, 10},
+			{"#0", []byte("0\n"), 0, 1},
+		},
+	},
+}
+
+func TestComputeEdits(t *testing.T) {
+	defer func(d func(string, []byte) ([]byte, error)) { runDiff = d }(runDiff)
+	for _, test := range diffTests {
+		runDiff = func(file string, new []byte) ([]byte, error) {
+			return []byte(test.diff), nil
+		}
+		edits, err := diffEdits("file", []byte(test.old), []byte(test.new), false)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(edits, test.edits) {
+			t.Errorf("%s: edits = %q, want %q", test.name, edits, test.edits)
+		}
+		if test.unified == "" {
+			continue
+		}
+		edits, err = computeEdits([]byte(test.unified), []byte(test.old), []byte(test.new), false)
+		if err != nil || !reflect.DeepEqual(edits, test.edits) {
+			t.Errorf("%s: unified diff edits = %q, %v, want %q", test.name, edits, err, test.edits)
+		}
+	}
+}
+
+// TestApplyEdits applies the edits of each test the way acme does,
+// addressing the body in runes rather than bytes, to check that they
+// land on the right lines of text with multibyte characters in it.
+func TestApplyEdits(t *testing.T) {
+	for _, test := range diffTests {
+		body := []rune(test.old)
+		for _, e := range test.edits {
+			q0, q1, ok := resolveAddr(body, e.addr)
+			if !ok {
+				t.Errorf("%s: cannot resolve address %q", test.name, e.addr)
+				break
+			}
+			body = append(body[:q0], append([]rune(string(e.data)), body[q1:]...)...)
+		}
+		if string(body) != test.new {
+			t.Errorf("%s: edited body = %q, want %q", test.name, string(body), test.new)
+		}
+	}
+}
+
+// resolveAddr returns the rune offsets that acme selects for addr in
+// body, for the forms of address computeEdits uses: "#0", "$", "n,m"
+// and "n+#0".
+func resolveAddr(body []rune, addr string) (q0, q1 int, ok bool) {
+	// lineStart returns the offset of the start of line n, or of the
+	// end of the body if it has fewer lines.
+	lineStart := func(n int) int {
+		q := 0
+		for ; n > 1 && q < len(body); q++ {
+			if body[q] == '\n' {
+				n--
+			}
+		}
+		return q
+	}
+	switch {
+	case addr == "#0":
+		return 0, 0, true
+	case addr == "$":
+		return len(body), len(body), true
+	case strings.HasSuffix(addr, "+#0"):
+		n, err := strconv.Atoi(strings.TrimSuffix(addr, "+#0"))
+		if err != nil {
+			return 0, 0, false
+		}
+		q := lineStart(n + 1)
+		return q, q, true
+	}
+	start, end, err := parseSpan(addr)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lineStart(start), lineStart(end + 1), true
+}
+
+func TestMergeEdits(t *testing.T) {
+	old, new := "a\nb\nc\nd\ne\nf\n", "x\nA\nb\nd\ne\ny\nf\n"
+	edits, err := computeEdits([]byte("0a1\n> x\n1c2\n< a\n---\n> A\n3d3\n< c\n5a6\n> y\n"), []byte(old), []byte(new), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		gap   int
+		edits []edit
+	}{
+		{0, []edit{{"5+#0", []byte("y\n"), 5, 6}, {"3,3", nil, 3, 3}, {"1,1", []byte("x\nA\n"), 1, 1}}},
+		{1, []edit{{"5+#0", []byte("y\n"), 5, 6}, {"1,3", []byte("x\nA\nb\n"), 1, 3}}},
+		{2, []edit{{"1,5", []byte("x\nA\nb\nd\ne\ny\n"), 1, 5}}},
+	} {
+		if merged := mergeEdits(edits, []byte(old), test.gap); !reflect.DeepEqual(merged, test.edits) {
+			t.Errorf("gap %d: edits = %q, want %q", test.gap, merged, test.edits)
+		}
+	}
+	// Merging never changes the result.
+	for _, test := range diffTests {
+		body := []rune(test.old)
+		for _, e := range mergeEdits(test.edits, []byte(test.old), 10) {
+			q0, q1, ok := resolveAddr(body, e.addr)
+			if !ok {
+				t.Errorf("%s: cannot resolve address %q", test.name, e.addr)
+				break
+			}
+			body = append(body[:q0], append([]rune(string(e.data)), body[q1:]...)...)
+		}
+		if string(body) != test.new {
+			t.Errorf("%s: merged edits give %q, want %q", test.name, string(body), test.new)
+		}
+	}
+}
+
+func TestComputeEditsIgnoreSpace(t *testing.T) {
+	old := []byte("a \nb\n")
+	new := []byte("a\nB\n")
+	edits, _ := computeEdits([]byte("1c1\n< a \n---\n> a\n"), old, new, true)
+	if len(edits) != 0 {
+		t.Errorf("space-only change: edits = %q, want none", edits)
+	}
+	edits, _ = computeEdits([]byte("1,2c1,2\n< a \n< b\n---\n> a\n> B\n"), old, new, true)
+	want := []edit{{"1,2", []byte("a\nB\n"), 1, 2}}
+	if !reflect.DeepEqual(edits, want) {
+		t.Errorf("mixed change: edits = %q, want %q", edits, want)
+	}
+}
+
+var badDiffs = []string{
+	"2x2\n",
+	"2,c2\n",
+	"1a,\n",
+}
+
+func TestComputeEditsError(t *testing.T) {
+	for _, diff := range badDiffs {
+		edits, err := computeEdits([]byte(diff), nil, nil, false)
+		if err == nil {
+			t.Errorf("computeEdits(%q) = %q, want error", diff, edits)
+		}
+	}
+}
+
+var parseDiffTests = []struct {
+	name    string
+	ed      string // diff(1) output
+	unified string // diff -U0 output for the same texts
+	edits   []Edit
+}{
+	{
+		"change one line",
+		"2c2\n< b\n---\n> B\n",
+		"@@ -2 +2 @@\n-b\n+B\n",
+		[]Edit{{Op: 'c', OldStart: 2, OldEnd: 2, NewStart: 2, NewEnd: 2}},
+	},
+	{
+		"change several lines",
+		"2,3c2\n< b\n< c\n---\n> B\n",
+		"@@ -2,2 +2 @@\n-b\n-c\n+B\n",
+		[]Edit{{Op: 'c', OldStart: 2, OldEnd: 3, NewStart: 2, NewEnd: 2}},
+	},
+	{
+		"add one line",
+		"1a2\n> x\n",
+		"@@ -1,0 +2 @@\n+x\n",
+		[]Edit{{Op: 'a', OldStart: 1, OldEnd: 1, NewStart: 2, NewEnd: 2}},
+	},
+	{
+		"add several lines",
+		"1a2,3\n> x\n> y\n",
+		"@@ -1,0 +2,2 @@\n+x\n+y\n",
+		[]Edit{{Op: 'a', OldStart: 1, OldEnd: 1, NewStart: 2, NewEnd: 3}},
+	},
+	{
+		"delete one line",
+		"2d1\n< b\n",
+		"@@ -2 +1,0 @@\n-b\n",
+		[]Edit{{Op: 'd', OldStart: 2, OldEnd: 2, NewStart: 1, NewEnd: 1}},
+	},
+	{
+		"delete several lines",
+		"2,3d1\n< b\n< c\n",
+		"@@ -2,2 +1,0 @@\n-b\n-c\n",
+		[]Edit{{Op: 'd', OldStart: 2, OldEnd: 3, NewStart: 1, NewEnd: 1}},
+	},
+	{
+		"add at start of file",
+		"0a1\n> a\n",
+		"@@ -0,0 +1 @@\n+a\n",
+		[]Edit{{Op: 'a', OldStart: 0, OldEnd: 0, NewStart: 1, NewEnd: 1}},
+	},
+	{
+		"delete at start of file",
+		"1d0\n< a\n",
+		"@@ -1 +0,0 @@\n-a\n",
+		[]Edit{{Op: 'd', OldStart: 1, OldEnd: 1, NewStart: 0, NewEnd: 0}},
+	},
+	{
+		"add at end of file",
+		"2a3\n> c\n",
+		"@@ -2,0 +3 @@\n+c\n",
+		[]Edit{{Op: 'a', OldStart: 2, OldEnd: 2, NewStart: 3, NewEnd: 3}},
+	},
+	{
+		"hunks at both ends",
+		"0a1\n> 0\n10c11\n< 10\n---\n> X\n",
+		"@@ -0,0 +1 @@\n+0\n@@ -10 +11 @@\n-10\n+X\n",
+		[]Edit{
+			{Op: 'a', OldStart: 0, OldEnd: 0, NewStart: 1, NewEnd: 1},
+			{Op: 'c', OldStart: 10, OldEnd: 10, NewStart: 11, NewEnd: 11},
+		},
+	},
+	{
+		"no newline at end of old",
+		"2c2\n< b\n\\ No newline at end of file\n---\n> b\n",
+		"@@ -2 +2 @@\n-b\n\\ No newline at end of file\n+b\n",
+		[]Edit{{Op: 'c', OldStart: 2, OldEnd: 2, NewStart: 2, NewEnd: 2, OldNoEOL: true}},
+	},
+	{
+		"no newline at end of new",
+		"2c2\n< b\n---\n> B\n\\ No newline at end of file\n",
+		"@@ -2 +2 @@\n-b\n+B\n\\ No newline at end of file\n",
+		[]Edit{{Op: 'c', OldStart: 2, OldEnd: 2, NewStart: 2, NewEnd: 2, NewNoEOL: true}},
+	},
+	{
+		"no newline at end of either",
+		"3c3\n< c\n\\ No newline at end of file\n---\n> C\n\\ No newline at end of file\n",
+		"@@ -3 +3 @@\n-c\n\\ No newline at end of file\n+C\n\\ No newline at end of file\n",
+		[]Edit{{Op: 'c', OldStart: 3, OldEnd: 3, NewStart: 3, NewEnd: 3, OldNoEOL: true, NewNoEOL: true}},
+	},
+	{
+		"no differences",
+		"",
+		"",
+		nil,
+	},
+}
+
+func TestParseEdDiff(t *testing.T) {
+	for _, test := range parseDiffTests {
+		edits, err := ParseEdDiff([]byte(test.ed))
+		if err != nil || !reflect.DeepEqual(edits, test.edits) {
+			t.Errorf("%s: ParseEdDiff(%q) = %+v, %v, want %+v", test.name, test.ed, edits, err, test.edits)
+		}
+	}
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	for _, test := range parseDiffTests {
+		edits, err := ParseUnifiedDiff([]byte(test.unified))
+		if err != nil || !reflect.DeepEqual(edits, test.edits) {
+			t.Errorf("%s: ParseUnifiedDiff(%q) = %+v, %v, want %+v", test.name, test.unified, edits, err, test.edits)
+		}
+	}
+	// Runs of changes between unchanged lines are hunks of their own,
+	// and the headers of files are skipped.
+	diff := "diff -u old new\n--- old\n+++ new\n@@ -1,5 +1,5 @@\n-a\n+A\n b\n\n d\n-e\n+E\n"
+	want := []Edit{
+		{Op: 'c', OldStart: 1, OldEnd: 1, NewStart: 1, NewEnd: 1},
+		{Op: 'c', OldStart: 5, OldEnd: 5, NewStart: 5, NewEnd: 5},
+	}
+	if edits, err := ParseUnifiedDiff([]byte(diff)); err != nil || !reflect.DeepEqual(edits, want) {
+		t.Errorf("ParseUnifiedDiff(%q) = %+v, %v, want %+v", diff, edits, err, want)
+	}
+}
+
+func TestParseDiffError(t *testing.T) {
+	for _, diff := range []string{
+		"2x2\n",
+		"> a\n",
+		"\\ No newline at end of file\n",
+		"@@ -1 +1 @@\n-a\n+A\n",
+	} {
+		if edits, err := ParseEdDiff([]byte(diff)); err == nil {
+			t.Errorf("ParseEdDiff(%q) = %+v, want error", diff, edits)
+		}
+	}
+	for _, diff := range []string{
+		"2c2\n< b\n---\n> B\n",
+		"@@ -1 +1 @@\n a\n b\n",
+		"@@ -1,2 +1,2 @@\n a\n",
+		"@@ -1 +1 @@\n*a\n",
+		"@@ -1 +1 @@\n\\ No newline at end of file\n",
+	} {
+		if edits, err := ParseUnifiedDiff([]byte(diff)); err == nil {
+			t.Errorf("ParseUnifiedDiff(%q) = %+v, want error", diff, edits)
+		}
+	}
+}
+
+func TestParseSpan(t *testing.T) {
+	for _, test := range []struct {
+		text       string
+		start, end int
+	}{
+		{"3", 3, 3},
+		{"0", 0, 0},
+		{"2,5", 2, 5},
+		{" 2, 5 ", 2, 5},
+	} {
+		start, end, err := parseSpan(test.text)
+		if err != nil || start != test.start || end != test.end {
+			t.Errorf("parseSpan(%q) = %d, %d, %v, want %d, %d", test.text, start, end, err, test.start, test.end)
+		}
+	}
+}
+
+var shiftDotTests = []struct {
+	name  string
+	edits []edit
+	q     int // in "a\nb\nc\nd\n"
+	want  int
+}{
+	{"lines added above", []edit{{"#0", []byte("x\ny\n"), 0, 1}}, 4, 8},
+	{"line deleted above", []edit{{"1,1", nil, 1, 1}}, 4, 2},
+	{"change below", []edit{{"4,4", []byte("D\nE\n"), 4, 4}}, 2, 2},
+	{"line reindented", []edit{{"3,3", []byte("\tc\n"), 3, 3}}, 5, 5},
+	{"inside lines joined", []edit{{"2,3", []byte("bc\n"), 2, 3}}, 4, 5},
+	{"start of changed line", []edit{{"3,3", []byte("C\n"), 3, 3}}, 4, 4},
+	{"several edits", []edit{{"4,4", []byte("D\n"), 4, 4}, {"1+#0", []byte("x\n"), 1, 2}}, 4, 6},
+	{"newline added at end", []edit{{"$", []byte("\n"), 0, 0}}, 6, 6},
+	{"whole body replaced", []edit{{"0,$", []byte("A\nB\n"), 0, 0}}, 3, 3},
+}
+
+func TestShiftDot(t *testing.T) {
+	old := []byte("a\nb\nc\nd\n")
+	for _, test := range shiftDotTests {
+		if q := shiftDot(old, test.edits, test.q); q != test.want {
+			t.Errorf("%s: shiftDot(%d) = %d, want %d", test.name, test.q, q, test.want)
+		}
+	}
+}
+
+// TestDescribeEdits checks that the edits of each test are described
+// as the commands of the diff they come from.
+func TestDescribeEdits(t *testing.T) {
+	for _, test := range diffTests {
+		var want []string
+		for _, line := range strings.Split(test.diff, "\n") {
+			if line != "" && line[0] >= '0' && line[0] <= '9' {
+				want = append(want, line)
+			}
+		}
+		var got []string
+		for _, cmd := range describeEdits(test.edits) {
+			if cmd != "newline at end of file" {
+				got = append(got, cmd)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: describeEdits = %q, want %q", test.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// DockerfileFmt formats Dockerfiles with cmd, dockerfmt, which prints
+// the formatted file. If cmd is not installed the file is tidied
+// in-process instead: instructions are written in upper case and the
+// lines that continue an instruction are indented by four blanks,
+// leaving here-documents alone. The file is then checked with lint,
+// hadolint, if it is installed; its warnings are shown in the +Errors
+// window, and do not keep the file from being formatted.
+type DockerfileFmt struct {
+	cmd  string
+	lint string
+}
+
+func (df *DockerfileFmt) format(file string) ([]byte, error) {
+	var new []byte
+	var err error
+	if _, lerr := exec.LookPath(df.cmd); df.cmd != "" && lerr == nil {
+		cmd := exec.Command(df.cmd, file)
+		cmd.Dir = resolveWorkdir(file, nil)
+		new, err = runCmd(cmd, file)
+		if err != nil {
+			fmtError(file, df.cmd, err, new)
+			return new, err
+		}
+	} else {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		new = tidyDockerfile(src)
+	}
+	if _, err := exec.LookPath(df.lint); df.lint != "" && err == nil {
+		cmd := exec.Command(df.lint, "--no-color", file)
+		cmd.Dir = resolveWorkdir(file, dockerMarkers)
+		if out, _ := cmd.CombinedOutput(); len(out) > 0 {
+			errorf(file, "%s %s:\n%s", df.lint, file, out)
+		}
+	}
+	return new, nil
+}
+
+// dockerInstructions are the instructions of a Dockerfile.
+var dockerInstructions = map[string]bool{
+	"ADD": true, "ARG": true, "CMD": true, "COPY": true, "ENTRYPOINT": true,
+	"ENV": true, "EXPOSE": true, "FROM": true, "HEALTHCHECK": true, "LABEL": true,
+	"MAINTAINER": true, "ONBUILD": true, "RUN": true, "SHELL": true,
+	"STOPSIGNAL": true, "USER": true, "VOLUME": true, "WORKDIR": true,
+}
+
+var (
+	escapeRE  = regexp.MustCompile(`(?i)^#\s*escape\s*=\s*(\S)\s*$`)
+	heredocRE = regexp.MustCompile(`<<(-?)["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+)
+
+func tidyDockerfile(src []byte) []byte {
+	escape := `\`
+	var (
+		buf      strings.Builder
+		cont     bool     // the line continues an instruction
+		heredocs []string // delimiters of the here-documents to come
+		strip    []bool   // whether each one may be indented with tabs
+		started  bool     // past the parser directives
+	)
+	for _, line := range strings.SplitAfter(string(src), "\n") {
+		text := strings.TrimRight(line, "\r\n")
+		eol := line[len(text):]
+		trimmed := strings.TrimLeft(text, " \t")
+		switch {
+		case len(heredocs) > 0:
+			end := text
+			if strip[0] {
+				end = strings.TrimLeft(end, "\t")
+			}
+			if end == heredocs[0] {
+				heredocs, strip = heredocs[1:], strip[1:]
+			}
+			buf.WriteString(line)
+			continue
+		case !started && strings.HasPrefix(trimmed, "#"):
+			if m := escapeRE.FindStringSubmatch(trimmed); m != nil {
+				escape = m[1]
+			}
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			// Comments and blank lines may come between the
+			// lines of an instruction.
+			started = true
+		case cont:
+			text = "    " + trimmed
+		default:
+			started = true
+			text = upperInstruction(trimmed)
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			cont = strings.HasSuffix(strings.TrimRight(text, " \t"), escape)
+			for _, m := range heredocRE.FindAllStringSubmatch(text, -1) {
+				heredocs = append(heredocs, m[2])
+				strip = append(strip, m[1] == "-")
+			}
+		}
+		buf.WriteString(text + eol)
+	}
+	return []byte(buf.String())
+}
+
+// upperInstruction returns line with its instruction, and that of an
+// ONBUILD, in upper case.
+func upperInstruction(line string) string {
+	word := firstWord(line)
+	upper := strings.ToUpper(word)
+	if !dockerInstructions[upper] {
+		return line
+	}
+	rest := line[len(word):]
+	if upper == "ONBUILD" {
+		trimmed := strings.TrimLeft(rest, " \t")
+		rest = rest[:len(rest)-len(trimmed)] + upperInstruction(trimmed)
+	}
+	return upper + rest
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+var dockerTests = []struct {
+	name     string
+	src, out string
+}{
+	{
+		name: "instruction casing",
+		src:  "from golang:1.13 as build\nRun go build\ncmd [\"/app\"]\n",
+		out:  "FROM golang:1.13 as build\nRUN go build\nCMD [\"/app\"]\n",
+	},
+	{
+		name: "onbuild",
+		src:  "onbuild copy . /src\n",
+		out:  "ONBUILD COPY . /src\n",
+	},
+	{
+		name: "continuation lines",
+		src:  "run apt-get update && \\\n\tapt-get install -y \\\n  # the compiler\n        gcc\nuser app\n",
+		out:  "RUN apt-get update && \\\n    apt-get install -y \\\n  # the compiler\n    gcc\nUSER app\n",
+	},
+	{
+		name: "escape directive",
+		src:  "# escape=`\nrun dir `\n  c:\\\nworkdir c:\\\n",
+		out:  "# escape=`\nRUN dir `\n    c:\\\nWORKDIR c:\\\n",
+	},
+	{
+		name: "here-documents",
+		src:  "run <<EOF\n  run  echo \\\nEOF\ncopy <<-A <<B /x\n\tcp a\n\tA\nb\nB\nenv x=1\n",
+		out:  "RUN <<EOF\n  run  echo \\\nEOF\nCOPY <<-A <<B /x\n\tcp a\n\tA\nb\nB\nENV x=1\n",
+	},
+	{
+		name: "unknown words",
+		src:  "  frobnicate x\n# from here\n",
+		out:  "frobnicate x\n# from here\n",
+	},
+	{
+		name: "CR LF",
+		src:  "from x\r\nrun a \\\r\n b\r\n",
+		out:  "FROM x\r\nRUN a \\\r\n    b\r\n",
+	},
+}
+
+func TestTidyDockerfile(t *testing.T) {
+	for _, test := range dockerTests {
+		out := tidyDockerfile([]byte(test.src))
+		if string(out) != test.out {
+			t.Errorf("%s:\n%q\nwant:\n%q", test.name, out, test.out)
+			continue
+		}
+		if again := tidyDockerfile(out); string(again) != string(out) {
+			t.Errorf("%s: not idempotent:\n%q", test.name, again)
+		}
+	}
+}
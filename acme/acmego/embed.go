@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// EmbedFmt formats a file with fmter, if any, and then the code of
+// other languages embedded in it, each with the formatter of its
+// language, writing back only those spans. An embedded region is tagged
+// JetBrains-style with a language=lang comment: in Go, the first raw
+// string literal on the line after such a // comment; in other files,
+// the body of a here-document whose << is on the line of such a #, //
+// or -- comment or the line after it. A region whose language has no
+// formatter, whose formatter fails or whose formatted code can no
+// longer be embedded, such as a raw string that would hold a back
+// quote, is left as it is.
+type EmbedFmt struct {
+	fmter Formatter
+	langs map[string]Formatter // by language
+}
+
+// embedFmts wraps the formatters in fmts of the extensions in the
+// comma-separated list exts in EmbedFmts, which format the embedded
+// code with the formatters of fmts or those of the lang=command list
+// cmds, adding one for the extensions that have none.
+func embedFmts(fmts map[string]Formatter, exts, cmds string) {
+	langs := make(map[string]Formatter)
+	for lang, fmter := range fmts {
+		langs[lang] = fmter
+	}
+	if cmds, err := extCommands(cmds); err == nil {
+		for lang, args := range cmds {
+			langs[lang] = &CmdFmt{args}
+		}
+	}
+	for ext := range splitSet(exts) {
+		fmts[ext] = &EmbedFmt{fmts[ext], langs}
+	}
+}
+
+// An embed is a span of a file, from start to end, holding code in
+// lang.
+type embed struct {
+	start, end int
+	lang       string
+}
+
+var embedTagRE = regexp.MustCompile(`(?:#|//|--)\s*language=([\w+-]+)`)
+
+// embedAliases maps the names languages are tagged with to the
+// extensions their formatters are registered for.
+var embedAliases = map[string]string{
+	"bash":       "sh",
+	"shell":      "sh",
+	"golang":     "go",
+	"javascript": "js",
+	"markdown":   "md",
+	"python":     "py",
+	"terraform":  "tf",
+	"yml":        "yaml",
+}
+
+func (e *EmbedFmt) format(file string) ([]byte, error) {
+	var new []byte
+	var err error
+	if e.fmter != nil {
+		new, err = e.fmter.format(file)
+	} else {
+		new, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return new, err
+	}
+	var embeds []embed
+	if fileExt(file) == "go" {
+		embeds = goEmbeds(new)
+	} else {
+		embeds = heredocEmbeds(new)
+	}
+	// Replace from the end so that the offsets of the rest hold.
+	for i := len(embeds) - 1; i >= 0; i-- {
+		em := embeds[i]
+		code, ok := e.formatEmbed(file, em.lang, new[em.start:em.end])
+		if !ok || bytes.Equal(code, new[em.start:em.end]) {
+			continue
+		}
+		if fileExt(file) == "go" && bytes.IndexByte(code, '`') >= 0 {
+			debugf("%s: formatted %s code has a back quote, left as it is", file, em.lang)
+			continue
+		}
+		new = append(new[:em.start:em.start], append(code, new[em.end:]...)...)
+	}
+	return new, nil
+}
+
+// formatEmbed returns code, embedded in file, formatted as lang, with
+// the lines it starts and ends with kept as they were, and whether it
+// could be formatted.
+func (e *EmbedFmt) formatEmbed(file, lang string, code []byte) ([]byte, bool) {
+	lang = strings.ToLower(lang)
+	if ext, ok := embedAliases[lang]; ok {
+		lang = ext
+	}
+	fmter, ok := e.langs[lang]
+	if !ok {
+		debugf("%s: no formatter for embedded %s code", file, lang)
+		return nil, false
+	}
+	tmp, err := tempFile(filepath.Dir(file), ".acmego-*."+lang, code)
+	if err != nil {
+		debugf("%s: %v", file, err)
+		return nil, false
+	}
+	defer os.Remove(tmp)
+	new, err := fmter.format(tmp)
+	if err != nil || len(bytes.TrimSpace(new)) == 0 {
+		debugf("%s: embedded %s code left as it is: %v", file, lang, err)
+		return nil, false
+	}
+	// A raw string starting on a line of its own starts with a
+	// newline, and one ending on a line of its own ends with a
+	// newline and the indentation of the closing back quote.
+	new = bytes.TrimRight(new, "\n")
+	if code[0] == '\n' && new[0] != '\n' {
+		new = append([]byte("\n"), new...)
+	}
+	if i := bytes.LastIndexByte(code, '\n'); i >= 0 && len(bytes.Trim(code[i+1:], " \t")) == 0 {
+		new = append(append(new, '\n'), code[i+1:]...)
+	}
+	return new, true
+}
+
+// goEmbeds returns the contents of the tagged raw string literals in
+// the Go source src, in the order they come. There are none if src
+// does not parse.
+func goEmbeds(src []byte) []embed {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	// The raw string literals by the line they start on.
+	lits := make(map[int][]*ast.BasicLit)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING && lit.Value[0] == '`' && len(lit.Value) > 2 {
+			line := fset.Position(lit.Pos()).Line
+			lits[line] = append(lits[line], lit)
+		}
+		return true
+	})
+	var embeds []embed
+	seen := make(map[*ast.BasicLit]bool)
+	for _, g := range f.Comments {
+		for _, c := range g.List {
+			m := embedTagRE.FindStringSubmatch(c.Text)
+			if m == nil || !strings.HasPrefix(c.Text, "//") {
+				continue
+			}
+			next := lits[fset.Position(c.End()).Line+1]
+			if len(next) == 0 || seen[next[0]] {
+				continue
+			}
+			lit := next[0]
+			seen[lit] = true
+			start := fset.Position(lit.Pos()).Offset
+			embeds = append(embeds, embed{start + 1, start + len(lit.Value) - 1, m[1]})
+		}
+	}
+	sort.Slice(embeds, func(i, j int) bool { return embeds[i].start < embeds[j].start })
+	return embeds
+}
+
+// heredocEmbeds returns the bodies of the tagged here-documents in src,
+// in the order they come. A here-document with no end is not one.
+func heredocEmbeds(src []byte) []embed {
+	lines := strings.SplitAfter(string(src), "\n")
+	// The offsets of the lines in src.
+	offs := make([]int, len(lines)+1)
+	for i, line := range lines {
+		offs[i+1] = offs[i] + len(line)
+	}
+	var embeds []embed
+	prev := "" // the language tagged on the line before
+	for i := 0; i < len(lines); i++ {
+		lang := prev
+		prev = ""
+		if m := embedTagRE.FindStringSubmatch(lines[i]); m != nil {
+			lang, prev = m[1], m[1]
+		}
+		m := heredocRE.FindStringSubmatch(lines[i])
+		if lang == "" || m == nil {
+			continue
+		}
+		delim := m[2]
+		for j := i + 1; j < len(lines); j++ {
+			end := strings.TrimRight(lines[j], "\r\n")
+			if m[1] == "-" {
+				end = strings.TrimLeft(end, "\t")
+			}
+			if end == delim {
+				if j > i+1 {
+					embeds = append(embeds, embed{offs[i+1], offs[j], lang})
+				}
+				i, prev = j, ""
+				break
+			}
+		}
+	}
+	return embeds
+}
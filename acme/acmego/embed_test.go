@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// upperFmt is a Formatter that upper-cases the file, trimming the white
+// space around it and adding a final newline, as a formatter would.
+type upperFmt struct{}
+
+func (upperFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.ToUpper(bytes.TrimSpace(src)), '\n'), nil
+}
+
+var embedTests = []struct {
+	name string
+	src  string
+	want string
+}{
+	{
+		"x.go",
+		"package p\n\n// language=sql\nconst q = `\n\tselect * from t\n\t`\n\nconst r = `select 1` // language=sql\n\nconst s = `select 2`\n",
+		"package p\n\n// language=sql\nconst q = `\nSELECT * FROM T\n\t`\n\nconst r = `select 1` // language=sql\n\nconst s = `select 2`\n",
+	},
+	{
+		// No formatter for the language, one that fails, and
+		// formatted code that would end the raw string.
+		"x.go",
+		"package p\n\n// language=css\nconst a = `a {}`\n\n// language=bad\nconst b = `b`\n\n// language=quote\nconst c = `x`\n",
+		"package p\n\n// language=css\nconst a = `a {}`\n\n// language=bad\nconst b = `b`\n\n// language=quote\nconst c = `x`\n",
+	},
+	{
+		"x.go",
+		"package p\n\n// language=sql\nconst q = `select\n",
+		"package p\n\n// language=sql\nconst q = `select\n",
+	},
+	{
+		"run.sh",
+		"# language=sql\npsql <<'EOF'\nselect 1;\nEOF\ncat <<EOF\nselect 2;\nEOF\nsqlite3 <<-END # language=sql\n\tselect 3;\n\tEND\n",
+		"# language=sql\npsql <<'EOF'\nSELECT 1;\nEOF\ncat <<EOF\nselect 2;\nEOF\nsqlite3 <<-END # language=sql\nSELECT 3;\n\tEND\n",
+	},
+	{
+		"run.sh",
+		"# language=sql\npsql <<EOF\nselect 1;\n",
+		"# language=sql\npsql <<EOF\nselect 1;\n",
+	},
+}
+
+func TestEmbedFmt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := &EmbedFmt{langs: map[string]Formatter{
+		"sql":   upperFmt{},
+		"bad":   &fakeFmt{err: errors.New("bad")},
+		"quote": &fakeFmt{out: []byte("`x`\n")},
+	}}
+	for _, test := range embedTests {
+		file := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(file, []byte(test.src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		new, err := e.format(file)
+		if err != nil || string(new) != test.want {
+			t.Errorf("format %q = %q, %v, want %q", test.src, new, err, test.want)
+		}
+	}
+}
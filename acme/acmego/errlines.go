@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// errLineRE matches the file:line or file:line:column prefix with
+// which most formatters start their error messages.
+var errLineRE = regexp.MustCompile(`(?m)^([^:\n]+):(\d+)(?::\d+)?:`)
+
+// errorLines returns the sorted, distinct line numbers of file that
+// the formatter output out reports errors for. Messages about other
+// files are ignored; those about standard input are taken to be about
+// file, as formatters reading stdin do not know its name.
+func errorLines(file string, out []byte) []int {
+	seen := make(map[int]bool)
+	var lines []int
+	for _, m := range errLineRE.FindAllSubmatch(out, -1) {
+		name := string(m[1])
+		switch name {
+		case "-", "<stdin>", "<standard input>", "stdin":
+		default:
+			if name != file && filepath.Base(name) != filepath.Base(file) {
+				continue
+			}
+		}
+		n, err := strconv.Atoi(string(m[2]))
+		if err != nil || n <= 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		lines = append(lines, n)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// showError moves the dot of w to the first line of file that the
+// formatter output out reports an error for, and shows it.
+// It does not change the body, so there is nothing to undo once the
+// error is fixed.
+func showError(w *Window, file string, out []byte) {
+	lines := errorLines(file, out)
+	if len(lines) == 0 {
+		return
+	}
+	if err := w.Addr("%d", lines[0]); err != nil {
+		return
+	}
+	w.Ctl("dot=addr")
+	w.Ctl("show")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+)
+
+// An ErrWindow is a +Errors window that the errors of formatters are
+// appended to. Unlike acme.Err, which looks the window up by name for
+// every message, it keeps the window open between events, opening it
+// again if it is deleted. Its lock keeps the messages of formats that
+// run at once from mixing.
+type ErrWindow struct {
+	name string
+
+	mu  sync.Mutex
+	win acmeWin // nil until the first message
+}
+
+// openErrWin opens the window with the given name, creating it if
+// there is none.
+var openErrWin = func(name string) (acmeWin, error) {
+	if w := acme.Show(name); w != nil {
+		return w, nil
+	}
+	w, err := acme.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Name("%s", name); err != nil {
+		w.CloseFiles()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Printf appends the message to the end of the window and shows it.
+// If there is no window to write to, the message is logged instead.
+func (e *ErrWindow) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// A window kept from before may have been deleted since, which
+	// shows when writing to it fails.
+	for try := 0; try < 2; try++ {
+		if e.win == nil {
+			w, err := openErrWin(e.name)
+			if err != nil {
+				log.Printf("%s: %v", e.name, err)
+				break
+			}
+			e.win = w
+		}
+		if err := e.append(msg); err == nil {
+			return
+		}
+		e.win.CloseFiles()
+		e.win = nil
+	}
+	log.Print(msg)
+}
+
+func (e *ErrWindow) append(msg string) error {
+	if err := e.win.Addr("$"); err != nil {
+		return err
+	}
+	if err := e.win.Ctl("dot=addr"); err != nil {
+		return err
+	}
+	if _, err := e.win.Write("body", []byte(msg)); err != nil {
+		return err
+	}
+	return e.win.Ctl("show")
+}
+
+// errWindows holds the +Errors windows, one for each directory as acme
+// keeps them, so that the errors of a file show next to it.
+type errWindows struct {
+	mu   sync.Mutex
+	wins map[string]*ErrWindow
+}
+
+var errWins = &errWindows{wins: make(map[string]*ErrWindow)}
+
+// errorf appends the message about file to the +Errors window of its
+// directory.
+func (ws *errWindows) errorf(file, format string, args ...interface{}) {
+	ws.window(file).Printf(format, args...)
+}
+
+func (ws *errWindows) window(file string) *ErrWindow {
+	dir, _ := path.Split(file)
+	if dir == "/" || dir == "." {
+		dir = ""
+	}
+	name := dir + "+Errors"
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	e := ws.wins[name]
+	if e == nil {
+		e = &ErrWindow{name: name}
+		ws.wins[name] = e
+	}
+	return e
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// deadWin is an acmeWin for a window deleted in acme, on which every
+// write fails.
+type deadWin struct {
+	fakeWin
+}
+
+func (w *deadWin) Addr(format string, args ...interface{}) error {
+	return errors.New("window deleted")
+}
+
+func TestErrWindow(t *testing.T) {
+	defer func(o func(string) (acmeWin, error)) { openErrWin = o }(openErrWin)
+	var opened []string
+	wins := []acmeWin{&deadWin{}, &fakeWin{}}
+	openErrWin = func(name string) (acmeWin, error) {
+		opened = append(opened, name)
+		w := wins[0]
+		wins = wins[1:]
+		return w, nil
+	}
+	ws := &errWindows{wins: make(map[string]*ErrWindow)}
+	ws.errorf("/a/x.go", "gofmt %s: %v", "/a/x.go", "exit status 2")
+	ws.errorf("/a/y.go", "yapf %s: failed\n", "/a/y.go")
+	if want := []string{"/a/+Errors", "/a/+Errors"}; !reflect.DeepEqual(opened, want) {
+		t.Errorf("opened %q, want %q", opened, want)
+	}
+	e := ws.window("/a/z.go")
+	w, ok := e.win.(*fakeWin)
+	if !ok {
+		t.Fatalf("window kept is %T, want the one opened after the deleted one", e.win)
+	}
+	want := []string{
+		"addr $", "ctl dot=addr", "body gofmt /a/x.go: exit status 2\n", "ctl show",
+		"addr $", "ctl dot=addr", "body yapf /a/y.go: failed\n", "ctl show",
+	}
+	if !reflect.DeepEqual(w.ops, want) {
+		t.Errorf("ops = %q, want %q", w.ops, want)
+	}
+	if ws.window("x.go").name != "+Errors" || ws.window("/x.go").name != "+Errors" {
+		t.Errorf("files at the root share no window")
+	}
+}
+
+// TestErrWindowConcurrent checks that messages written at once are
+// written one after another, each whole.
+func TestErrWindowConcurrent(t *testing.T) {
+	defer func(o func(string) (acmeWin, error)) { openErrWin = o }(openErrWin)
+	w := &fakeWin{}
+	openErrWin = func(name string) (acmeWin, error) { return w, nil }
+	e := &ErrWindow{name: "+Errors"}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e.Printf("error %d", i)
+		}(i)
+	}
+	wg.Wait()
+	if len(w.ops) != 4*20 {
+		t.Fatalf("%d ops, want %d", len(w.ops), 4*20)
+	}
+	for i := 0; i < len(w.ops); i += 4 {
+		if w.ops[i] != "addr $" || w.ops[i+1] != "ctl dot=addr" || !strings.HasPrefix(w.ops[i+2], "body error ") || w.ops[i+3] != "ctl show" {
+			t.Fatalf("messages mixed: %q", w.ops[i:i+4])
+		}
+	}
+	seen := make(map[string]bool)
+	for i := 2; i < len(w.ops); i += 4 {
+		seen[w.ops[i]] = true
+	}
+	for i := 0; i < 20; i++ {
+		if msg := fmt.Sprintf("body error %d\n", i); !seen[msg] {
+			t.Errorf("missing %q", msg)
+		}
+	}
+}
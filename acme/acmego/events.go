@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A fmtEvent records one run of a formatter by reformat, for tools
+// that follow the -events output rather than the log.
+type fmtEvent struct {
+	File      string `json:"file"`
+	Ext       string `json:"ext"`
+	Formatter string `json:"formatter"`
+	Duration  int64  `json:"duration_ms"`
+	Hunks     int    `json:"hunks"`   // edits made to the window
+	Changed   bool   `json:"changed"` // whether the window was edited
+	Error     string `json:"error,omitempty"`
+
+	start time.Time
+}
+
+// An eventLog writes events as JSON, one object to a line. The zero
+// eventLog writes nothing.
+type eventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// openEventLog returns an eventLog writing to file, appending to it,
+// or to standard output if file is "-".
+func openEventLog(file string) (*eventLog, error) {
+	var w io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &eventLog{enc: json.NewEncoder(w)}, nil
+}
+
+// start returns an event for formatting file with fmter, to be
+// passed to done when it is over, or nil if l writes nothing.
+func (l *eventLog) start(file string, fmter Formatter) *fmtEvent {
+	if l == nil || l.enc == nil {
+		return nil
+	}
+	return &fmtEvent{
+		File:      file,
+		Ext:       fileExt(file),
+		Formatter: fmterName(fmter),
+		start:     time.Now(),
+	}
+}
+
+// done writes ev, started by start, with the number of edits made to
+// the window and the error, if any, that stopped the formatting.
+func (l *eventLog) done(ev *fmtEvent, hunks int, err error) {
+	if ev == nil {
+		return
+	}
+	ev.Duration = int64(time.Since(ev.start) / time.Millisecond)
+	ev.Hunks, ev.Changed = hunks, hunks > 0
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(ev); err != nil {
+		log.Printf("writing event: %v", err)
+	}
+}
+
+// fmterName returns the name of the type of fmter, looking through
+// the limits put on it with -jobs.
+func fmterName(fmter Formatter) string {
+	if l, ok := fmter.(*LimitFmt); ok {
+		fmter = l.fmter
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", fmter), "*main.")
+}
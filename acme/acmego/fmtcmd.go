@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fmtMain runs "acmego fmt", which formats standard input with the
+// formatter acmego uses for files with the extension given by -ext
+// and writes the result to standard output. The flags given before
+// "fmt" configure the formatters as they do for windows. Input with
+// no formatter is copied out unchanged.
+func fmtMain(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	ext := fs.String("ext", "", "format standard input as a file with extension `ext`")
+	fs.Parse(args)
+	if *ext == "" || fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "usage: acmego [flags] fmt -ext ext\n")
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("acmego fmt: ")
+	errorf = func(file, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if !strings.HasSuffix(msg, "\n") {
+			msg += "\n"
+		}
+		os.Stderr.WriteString(msg)
+	}
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := formatContent(newRegistry(newFmts()), strings.TrimPrefix(*ext, "."), ".", src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(out)
+}
+
+// formatContent returns src formatted as the contents of a file with
+// extension ext in dir, where the formatter finds its configuration.
+// If there is no formatter for ext, src is returned as it is.
+func formatContent(fmts *registry, ext, dir string, src []byte) ([]byte, error) {
+	fmter, ok := fmts.lookup(ext)
+	if !ok && !*allowlist {
+		fmter, ok = fmts.lookup("anyext")
+	}
+	if !ok {
+		return src, nil
+	}
+	// Formatters may run in another directory, so the name of the
+	// file they are given must not be relative.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := tempFile(dir, ".acmego-*."+ext, src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	out, err := fmter.format(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 && len(src) > 0 && !splitSet(*emptyExts)[ext] {
+		return nil, errors.New("formatter output is empty")
+	}
+	return out, nil
+}
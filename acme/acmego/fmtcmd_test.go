@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFormatContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fmts := newRegistry(map[string]Formatter{"txt": &TrimFmt{space: true, newline: true}})
+	out, err := formatContent(fmts, "txt", dir, []byte("a  \nb"))
+	if err != nil || string(out) != "a\nb\n" {
+		t.Errorf("formatContent(txt) = %q, %v, want %q", out, err, "a\nb\n")
+	}
+	out, err = formatContent(fmts, "go", dir, []byte("a  "))
+	if err != nil || string(out) != "a  " {
+		t.Errorf("formatContent(go) = %q, %v, want the input unchanged", out, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left in the directory, want none", len(files))
+	}
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Formatter interface {
@@ -20,46 +27,294 @@ func buildCmd(cmd, file string, args ...string) *exec.Cmd {
 	return exec.Command(cmd, file)
 }
 
+// pipeCmd runs cmd with the contents of file on its standard input and
+// returns its output as runCmd does.
+func pipeCmd(cmd *exec.Cmd, file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cmd.Stdin = f
+	return runCmd(cmd, file)
+}
+
+// runCmd runs cmd, a formatter for file, and returns what it writes to
+// standard output. If the command fails the returned bytes are what it
+// wrote to standard error instead. Formatters are kept from mixing
+// their diagnostics into the code this way; those of a command that
+// succeeds are shown in the +Errors window, as warnings.
+func runCmd(cmd *exec.Cmd, file string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := waitCmd(cmd); err != nil {
+		return stderr.Bytes(), err
+	}
+	if stderr.Len() > 0 {
+		errorf(file, "%s %s:\n%s", cmd.Args[0], file, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// waitCmd runs cmd at the scheduling priority set with -nice, so that
+// a heavy formatter does not slow down acme, and waits for it to finish
+// for at most the -timeout.
+func waitCmd(cmd *exec.Cmd) error {
+	return waitCmdTimeout(cmd, *cmdTimeout)
+}
+
+// waitCmdTimeout is like waitCmd but waits for at most timeout, unless
+// it is 0. A command that takes longer is killed, and all the processes
+// it started with it, so that a formatter hanging, say on a wedged
+// server it shells out to, does not block the event loop for good.
+func waitCmdTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	setGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if *niceness != 0 {
+		if err := setNice(cmd.Process.Pid, *niceness); err != nil {
+			log.Printf("%s: %v", cmd.Args[0], err)
+		}
+	}
+	if timeout <= 0 {
+		return cmd.Wait()
+	}
+	var mu sync.Mutex
+	timedOut := false
+	t := time.AfterFunc(timeout, func() {
+		mu.Lock()
+		timedOut = true
+		mu.Unlock()
+		// The processes left behind would keep the output pipes
+		// open, and Wait with them.
+		killGroup(cmd)
+	})
+	err := cmd.Wait()
+	t.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if timedOut {
+		return &timeoutError{timeout}
+	}
+	return err
+}
+
+// A timeoutError reports that a command was killed for taking longer
+// than the timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v, killed", e.timeout)
+}
+
+// errorf reports the errors and warnings of a formatter for file: in
+// the +Errors window, or on standard error for "acmego fmt".
+var errorf = errWins.errorf
+
+// fmtError reports in the +Errors window that tool failed on file.
+func fmtError(file, tool string, err error, out []byte) {
+	errorf(file, "%s %s: %v\n%s", tool, file, err, out)
+}
+
+// GoImportFmt formats Go code with goimports. The build tags, a
+// comma-separated list, are given to goimports so that it finds the
+// packages a file imports under them; if there are none, the tags
+// required by the file's own build constraints are used. Imports with
+// the prefix local are grouped apart from the others; by default the
+// prefix is the path of the module the file is in, looked up in mods.
 type GoImportFmt struct {
-	cmd string
+	cmd   string
+	tags  string
+	local string
+	mods  *modCache
 }
 
 func (g *GoImportFmt) format(file string) ([]byte, error) {
-	cmd := buildCmd(g.cmd, file)
-	// Grab the parent directory of the file where we are going to execute
-	// the command.
-	cmd.Dir = filepath.Dir(file)
-	new, err := cmd.CombinedOutput()
-	if err != nil {
-		// Probably a syntax error, use the compiler for better message.
-		// For now use 'go build file.go' and strip the package header.
-		// We run it in /var/run so that paths do not get shortened
-		// (assuming /var/run exists and no one is editing go files under that path).
-		// A better fix to both would be to use go tool 6g, but we don't know
-		// whether 6g is the right architecture. Could parse 'go env' output.
-		// Or maybe the go command should have 'go tool compile' and 'go tool link'.
-		cmd := exec.Command("go", "build", file)
-		cmd.Dir = "/var/run"
-		out, _ := cmd.CombinedOutput()
-		start := []byte("# command-line-arguments\n")
-		if !bytes.HasPrefix(out, start) {
-			fmt.Fprintf(os.Stderr, "goimports %s: %v\n%s", file, err, new)
+	tags := g.tags
+	if tags == "" {
+		tags = fileTags(file)
+	}
+	name := g.cmd
+	if filepath.Base(name) == "goimports" && usesCgo(file) {
+		// goimports may move import "C" away from the comment above
+		// it, the preamble cgo compiles. gofmt leaves imports alone.
+		name = "gofmt"
+	}
+	var args []string
+	if filepath.Base(name) == "goimports" {
+		local := g.local
+		if local == "" && g.mods != nil {
+			local = g.mods.modulePath(filepath.Dir(file))
+		}
+		if local != "" {
+			args = []string{"-local", local}
+		}
+	}
+	cmd := buildCmd(name, file, args...)
+	cmd.Dir = resolveWorkdir(file, goMarkers)
+	cmd.Env = tagsEnv(tags)
+	new, err := runCmd(cmd, file)
+	if err != nil {
+		out, ok := goBuildErrors(file, tags)
+		if bytes.Contains(out, []byte("build constraints exclude")) {
+			errorf(file, "goimports %s: build constraints exclude the file, "+
+				"so its imports cannot be resolved; give its tags with -buildtags", file)
+		}
+		if !ok {
+			fmtError(file, name, err, new)
 			return new, err
 		}
-		fmt.Fprintf(os.Stderr, "%s", out)
+		errorf(file, "go build %s:\n%s", file, out)
 	}
 	return new, err
 }
 
+// GofumptFmt formats Go code with gofumpt, for its stricter style than
+// gofmt's. Like gofmt, it leaves the imports alone. The build tags are
+// those the compiler is run with to explain a syntax error, as for
+// GoImportFmt.
+type GofumptFmt struct {
+	cmd  string
+	tags string
+}
+
+func (g *GofumptFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(g.cmd, file)
+	// gofumpt reads the Go version of the module from its go.mod.
+	cmd.Dir = resolveWorkdir(file, goMarkers)
+	new, err := runCmd(cmd, file)
+	if err != nil {
+		tags := g.tags
+		if tags == "" {
+			tags = fileTags(file)
+		}
+		out, ok := goBuildErrors(file, tags)
+		if !ok {
+			fmtError(file, g.cmd, err, new)
+			return new, err
+		}
+		errorf(file, "go build %s:\n%s", file, out)
+	}
+	return new, err
+}
+
+// goBuildErrors returns the errors of the compiler for file, built
+// with the build tags, when a Go formatter fails on it: probably for
+// a syntax error, which the compiler explains better. The package
+// header is stripped from them. If the output of go build is not the
+// compiler's, as when go is not installed, it is returned as it is,
+// with ok false.
+func goBuildErrors(file, tags string) (out []byte, ok bool) {
+	// We run it in /var/run so that paths do not get shortened
+	// (assuming /var/run exists and no one is editing go files under that path).
+	// A better fix would be to use go tool 6g, but we don't know
+	// whether 6g is the right architecture. Could parse 'go env' output.
+	// Or maybe the go command should have 'go tool compile' and 'go tool link'.
+	cmd := exec.Command("go", "build", file)
+	cmd.Dir = "/var/run"
+	cmd.Env = tagsEnv(tags)
+	out, _ = cmd.CombinedOutput()
+	start := []byte("# command-line-arguments\n")
+	if !bytes.HasPrefix(out, start) {
+		return out, false
+	}
+	return out[len(start):], true
+}
+
+// usesCgo reports whether the Go file imports "C".
+func usesCgo(file string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, imp := range f.Imports {
+		if imp.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// GoRewriteFmt applies the gofmt rewrite rules, each of the form
+// "pattern -> replacement", to Go code, such as to enforce a project's
+// codemods on save. The rules are applied in order, each to the output
+// of the one before it, and the whole list again until the code no
+// longer changes, so that formatting the result once more leaves it as
+// it is, whatever the order the rules are given in.
+type GoRewriteFmt struct {
+	cmd   string
+	rules []string
+}
+
+// maxRewritePasses bounds the passes of GoRewriteFmt over the rules,
+// some of which may undo what others do and never settle.
+const maxRewritePasses = 10
+
+func (g *GoRewriteFmt) format(file string) ([]byte, error) {
+	new, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for pass := 0; pass < maxRewritePasses; pass++ {
+		old := new
+		for _, rule := range g.rules {
+			cmd := exec.Command(g.cmd, "-r", rule)
+			cmd.Dir = filepath.Dir(file)
+			cmd.Stdin = bytes.NewReader(new)
+			out, err := runCmd(cmd, file)
+			if err != nil {
+				fmtError(file, g.cmd+" -r '"+rule+"'", err, out)
+				return out, err
+			}
+			new = out
+		}
+		if bytes.Equal(new, old) {
+			return new, nil
+		}
+	}
+	err = fmt.Errorf("rewrite rules still change the code after %d passes", maxRewritePasses)
+	fmtError(file, g.cmd+" -r", err, nil)
+	return nil, err
+}
+
+// parseRewriteRules returns the gofmt rewrite rules in the
+// semicolon-separated list s, checking that each is of the form
+// "pattern -> replacement" with both sides Go expressions.
+func parseRewriteRules(s string) ([]string, error) {
+	var rules []string
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		f := strings.Split(rule, "->")
+		if len(f) != 2 {
+			return nil, fmt.Errorf("rewrite rule %q is not of the form pattern -> replacement", rule)
+		}
+		for _, x := range f {
+			if _, err := parser.ParseExpr(strings.TrimSpace(x)); err != nil {
+				return nil, fmt.Errorf("rewrite rule %q: %v", rule, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
 type PyFmt struct {
 	cmd string
 }
 
 func (py *PyFmt) format(file string) ([]byte, error) {
 	cmd := buildCmd(py.cmd, file)
-	new, err := cmd.CombinedOutput()
+	cmd.Dir = resolveWorkdir(file, pyMarkers)
+	new, err := runCmd(cmd, file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "yapf %s: %v\n%s", file, err, new)
+		fmtError(file, "yapf", err, new)
 	}
 	return new, err
 }
@@ -70,9 +325,10 @@ type RustFmt struct {
 
 func (rs *RustFmt) format(file string) ([]byte, error) {
 	cmd := buildCmd(rs.cmd, file)
-	new, err := cmd.CombinedOutput()
+	cmd.Dir = resolveWorkdir(file, rustMarkers)
+	new, err := runCmd(cmd, file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: %v\n%s", rs.cmd, file, err, new)
+		fmtError(file, rs.cmd, err, new)
 	}
 	return new, err
 }
@@ -86,9 +342,10 @@ type DefaultEolFmt struct {
 
 func (df *DefaultEolFmt) format(file string) ([]byte, error) {
 	cmd := buildCmd(df.cmd, file)
-	new, err := cmd.CombinedOutput()
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := runCmd(cmd, file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "default fmt eol %s: %v\n%s", file, err, new)
+		fmtError(file, "default fmt eol", err, new)
 	}
 	return new, err
 }
@@ -99,24 +356,558 @@ type ElmFmt struct {
 
 func (el *ElmFmt) format(file string) ([]byte, error) {
 	cmd := buildCmd(el.cmd, file)
-	new, err := cmd.CombinedOutput()
+	cmd.Dir = resolveWorkdir(file, elmMarkers)
+	new, err := runCmd(cmd, file)
+	if err != nil {
+		fmtError(file, el.cmd, err, new)
+	}
+	return new, err
+}
+
+// NixFmt formats Nix expressions with either nixpkgs-fmt or alejandra.
+// Both read the source on stdin and write the result to stdout.
+type NixFmt struct {
+	cmd  string
+	args []string
+}
+
+func (nx *NixFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(nx.cmd, nx.args...)
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := pipeCmd(cmd, file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: %v\n%s", el.cmd, file, err, new)
+		fmtError(file, nx.cmd, err, new)
 	}
 	return new, err
 }
 
+func newNixFmt(cmd string) *NixFmt {
+	nx := &NixFmt{cmd: cmd}
+	if filepath.Base(cmd) == "alejandra" {
+		// Keep alejandra from printing its banner.
+		nx.args = []string{"--quiet"}
+	}
+	return nx
+}
+
+// LuaFmt formats Lua code with stylua. The source is piped through
+// stylua (which rewrites files in place when given their names) and
+// the command runs in the directory of the nearest stylua.toml, where
+// stylua starts its search for one. Extra arguments, such as indentation
+// options, are passed through args.
+type LuaFmt struct {
+	cmd  string
+	args []string
+}
+
+func (lu *LuaFmt) format(file string) ([]byte, error) {
+	cmd := buildCmd(lu.cmd, "-", lu.args...)
+	cmd.Dir = resolveWorkdir(file, luaMarkers)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, lu.cmd, err, new)
+	}
+	return new, err
+}
+
+// OcamlFmt formats OCaml code with ocamlformat, which prints the
+// formatted file to stdout. It refuses to format files outside of a
+// project with a .ocamlformat file, which it looks for from the
+// directory of the file up.
+type OcamlFmt struct {
+	cmd string
+}
+
+func (ml *OcamlFmt) format(file string) ([]byte, error) {
+	cmd := buildCmd(ml.cmd, file)
+	cmd.Dir = resolveWorkdir(file, ocamlMarkers)
+	new, err := runCmd(cmd, file)
+	if err != nil {
+		if bytes.Contains(new, []byte(".ocamlformat")) {
+			errorf(file, "%s %s: no .ocamlformat file found for this project; "+
+				"create one, even an empty one, to enable formatting\n%s", ml.cmd, file, new)
+		} else {
+			fmtError(file, ml.cmd, err, new)
+		}
+	}
+	return new, err
+}
+
+// HclFmt formats Terraform and HCL files with "terraform fmt" or
+// OpenTofu's "tofu fmt". Both are given the source on stdin so they
+// write the result to stdout rather than rewriting the file.
+type HclFmt struct {
+	cmd string
+}
+
+func (tf *HclFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(tf.cmd, "fmt", "-")
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, tf.cmd+" fmt", err, new)
+	}
+	return new, err
+}
+
+// JsonnetFmt formats Jsonnet code with jsonnetfmt, reading the
+// source from stdin.
+type JsonnetFmt struct {
+	cmd string
+}
+
+func (js *JsonnetFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(js.cmd, "-")
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, js.cmd, err, new)
+	}
+	return new, err
+}
+
+// CueFmt formats CUE with "cue fmt", which reads the source on stdin
+// when given "-" for a file and writes the result to stdout, rather
+// than rewriting the file in place.
+type CueFmt struct {
+	cmd string
+}
+
+func (cu *CueFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(cu.cmd, "fmt", "-")
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, cu.cmd+" fmt", err, new)
+	}
+	return new, err
+}
+
+// DartFmt formats Dart code with "dart format". Given file names,
+// dart format rewrites them in place, so the source is given on stdin
+// instead and the result read from stdout.
+type DartFmt struct {
+	cmd string
+}
+
+func (dt *DartFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(dt.cmd, "format", "--stdin-name="+file)
+	cmd.Dir = resolveWorkdir(file, dartMarkers)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, dt.cmd+" format", err, new)
+	}
+	return new, err
+}
+
+// PrettierFmt formats JavaScript, TypeScript, CSS and Markdown with
+// prettier. Given file names, prettier --write rewrites them in place,
+// so the source is given on stdin, with the file name to choose the
+// parser by and find the project's configuration from, and the result
+// read from stdout. It is run at the root of the project, so that the
+// project's own prettier and plugins are found. If cmd is not
+// installed, the file is formatted with tidy instead, if not nil.
+type PrettierFmt struct {
+	cmd  string
+	tidy Formatter
+}
+
+func (pf *PrettierFmt) format(file string) ([]byte, error) {
+	if _, err := exec.LookPath(pf.cmd); err != nil && pf.tidy != nil {
+		return pf.tidy.format(file)
+	}
+	cmd := exec.Command(pf.cmd, "--stdin-filepath", file)
+	cmd.Dir = resolveWorkdir(file, jsMarkers)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, pf.cmd, err, new)
+	}
+	return new, err
+}
+
+// SwiftFmt formats Swift code with swift-format, reading the source
+// on stdin. With no file name to go by, swift-format looks for its
+// .swift-format configuration from the working directory up, so it
+// is run in the directory of the nearest one.
+type SwiftFmt struct {
+	cmd string
+}
+
+func (sw *SwiftFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(sw.cmd, "-")
+	cmd.Dir = resolveWorkdir(file, swiftMarkers)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, sw.cmd, err, new)
+	}
+	return new, err
+}
+
+// AsmFmt formats Go assembly with asmfmt, which reads the source on
+// stdin when given no files and writes the result to stdout.
+type AsmFmt struct {
+	cmd string
+}
+
+func (as *AsmFmt) format(file string) ([]byte, error) {
+	cmd := exec.Command(as.cmd)
+	cmd.Dir = resolveWorkdir(file, nil)
+	new, err := pipeCmd(cmd, file)
+	if err != nil {
+		fmtError(file, as.cmd, err, new)
+	}
+	return new, err
+}
+
+// RubyFmt formats Ruby code with "rubocop -A", or -a for versions of
+// rubocop that predate -A, or "standardrb --fix".
+// Both read the source on stdin, needing the file name only to find
+// their configuration, and print the corrected source on stdout. The
+// offenses they cannot correct are reported in the +Errors window,
+// and as they are slow to start, the command is killed after a timeout
+// of its own.
+type RubyFmt struct {
+	cmd     string
+	timeout time.Duration
+}
+
+func (rb *RubyFmt) format(file string) ([]byte, error) {
+	args := []string{"-A"}
+	if filepath.Base(rb.cmd) == "standardrb" {
+		args = []string{"--fix"}
+	} else if v, ok := toolVersion(rb.cmd); ok && v.less(version{0, 87}) {
+		// Older versions only have -a, for the safe corrections.
+		args = []string{"-a"}
+	}
+	args = append(args, "--stderr", "--stdin", file)
+	cmd := exec.Command(rb.cmd, args...)
+	cmd.Dir = resolveWorkdir(file, rubyMarkers)
+	src, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	cmd.Stdin = src
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = waitCmdTimeout(cmd, rb.timeout)
+	new := stdout.Bytes()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 && len(new) > 0 {
+		// Exit status 1 means offenses remain after correction,
+		// which does not make the output any less valid.
+		errorf(file, "%s %s:\n%s", rb.cmd, file, stderr.Bytes())
+		return new, nil
+	}
+	if err != nil {
+		fmtError(file, rb.cmd, err, stderr.Bytes())
+		return stderr.Bytes(), err
+	}
+	return new, nil
+}
+
+// PhpFmt formats PHP code with php-cs-fixer or phpcbf. Neither can
+// print the fixed source, so a copy of the file is fixed in place and
+// read back. The copy is made in the directory of the file, and the
+// command runs in that of the nearest .php-cs-fixer.php or phpcs.xml
+// so that it finds its configuration.
+type PhpFmt struct {
+	cmd string
+}
+
+func (ph *PhpFmt) format(file string) ([]byte, error) {
+	tmp, err := tempCopy(file)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	cbf := filepath.Base(ph.cmd) == "phpcbf"
+	cmd := exec.Command(ph.cmd, "fix", "--quiet", "--using-cache=no", tmp)
+	if cbf {
+		cmd = exec.Command(ph.cmd, "-q", tmp)
+	}
+	cmd.Dir = resolveWorkdir(file, phpMarkers)
+	out, err := runCmd(cmd, file)
+	if e, ok := err.(*exec.ExitError); ok && cbf && e.ExitCode() <= 2 {
+		// phpcbf exits with 1 when it fixed the file and with 2
+		// when errors remain that it could not fix.
+		err = nil
+	}
+	if err != nil {
+		fmtError(file, ph.cmd, err, out)
+		return out, err
+	}
+	return ioutil.ReadFile(tmp)
+}
+
+// KotlinFmt formats Kotlin code with "ktlint -F" or ktfmt, which both
+// fix files in place, so a copy of the file is fixed and read back as
+// PhpFmt does. The lint errors ktlint cannot fix are reported in the
+// +Errors window.
+type KotlinFmt struct {
+	cmd string
+}
+
+func (kt *KotlinFmt) format(file string) ([]byte, error) {
+	tmp, err := tempCopy(file)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	lint := filepath.Base(kt.cmd) != "ktfmt"
+	cmd := exec.Command(kt.cmd, tmp)
+	if lint {
+		cmd = exec.Command(kt.cmd, "-F", tmp)
+	}
+	cmd.Dir = resolveWorkdir(file, kotlinMarkers)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = waitCmd(cmd)
+	if e, ok := err.(*exec.ExitError); ok && lint && e.ExitCode() == 1 {
+		// Exit status 1 means errors remain after fixing; they
+		// are listed against the copy, so name the file instead.
+		errorf(file, "%s %s:\n%s", kt.cmd, file, bytes.Replace(out.Bytes(), []byte(tmp), []byte(file), -1))
+		err = nil
+	}
+	if err != nil {
+		fmtError(file, kt.cmd, err, out.Bytes())
+		return out.Bytes(), err
+	}
+	return ioutil.ReadFile(tmp)
+}
+
+// CmdFmt formats with a command of the user's, args, given the name of
+// the file after its own arguments and printing the formatted file. It
+// is run in the directory of the file.
+type CmdFmt struct {
+	args []string
+}
+
+func (c *CmdFmt) format(file string) ([]byte, error) {
+	cmd := buildCmd(c.args[0], file, c.args[1:]...)
+	cmd.Dir = filepath.Dir(file)
+	new, err := runCmd(cmd, file)
+	if err != nil {
+		fmtError(file, c.args[0], err, new)
+	}
+	return new, err
+}
+
+// tempCopy copies file to a new temporary file next to it, with the
+// same extension, for formatters that only rewrite files in place.
+// Being in the same directory, the copy falls under the same project
+// configuration as the file.
+func tempCopy(file string) (string, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return tempFile(filepath.Dir(file), ".acmego-*"+filepath.Ext(file), src)
+}
+
+// FallbackFmt formats with primary and, only if that fails, with
+// fallback instead, so that a file still gets some formatting when
+// the preferred tool cannot handle it. Unlike a chain of formatters,
+// fallback never runs when primary succeeds.
+type FallbackFmt struct {
+	primary  Formatter
+	fallback Formatter
+}
+
+func (fb *FallbackFmt) format(file string) ([]byte, error) {
+	new, err := fb.primary.format(file)
+	if err == nil {
+		return new, nil
+	}
+	return fb.fallback.format(file)
+}
+
+// ChainFmt formats with each of fmts in turn, every one formatting
+// the output of the one before it, which is written to a temporary
+// file next to the original for it. The chain stops at the first
+// formatter to fail. An empty chain leaves the file as it is.
+type ChainFmt struct {
+	fmts []Formatter
+}
+
+func (ch *ChainFmt) format(file string) ([]byte, error) {
+	new, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	src := file
+	for i, fmter := range ch.fmts {
+		if i > 0 {
+			tmp, err := tempFile(filepath.Dir(file), ".acmego-*"+filepath.Ext(file), new)
+			if err != nil {
+				return nil, err
+			}
+			defer os.Remove(tmp)
+			src = tmp
+		}
+		new, err = fmter.format(src)
+		if err != nil {
+			return new, err
+		}
+	}
+	return new, nil
+}
+
+// LimitFmt formats with fmter, letting at most cap(sem) formats run at
+// once. The others wait for their turn rather than start yet another
+// expensive process.
+type LimitFmt struct {
+	fmter Formatter
+	sem   chan struct{}
+}
+
+func (l *LimitFmt) format(file string) ([]byte, error) {
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	return l.fmter.format(file)
+}
+
+// jobSems holds the semaphores of the LimitFmts for each extension,
+// so that they are shared with those made when the formatters are
+// reloaded.
+var jobSems = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: make(map[string]chan struct{})}
+
+// limitJobs wraps the formatters in fmts of the extensions in limits
+// in LimitFmts, to run at most as many formats at once as the limit.
+func limitJobs(fmts map[string]Formatter, limits map[string]int64) {
+	jobSems.Lock()
+	defer jobSems.Unlock()
+	for ext, n := range limits {
+		fmter, ok := fmts[ext]
+		if !ok || n <= 0 {
+			continue
+		}
+		sem := jobSems.m[ext]
+		if cap(sem) != int(n) {
+			sem = make(chan struct{}, n)
+			jobSems.m[ext] = sem
+		}
+		fmts[ext] = &LimitFmt{fmter, sem}
+	}
+}
+
+// newAnyextFmt returns the formatter for files with no formatter of
+// their own, a chain of those named in the comma-separated list: the
+// external aeol, and the built-in trim for trailing white space and eol
+// for the final newline.
+func newAnyextFmt(list string) *ChainFmt {
+	ch := new(ChainFmt)
+	for _, name := range strings.Split(list, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "aeol":
+			ch.fmts = append(ch.fmts, &DefaultEolFmt{cmd: "aeol"})
+		case "trim":
+			ch.fmts = append(ch.fmts, &TrimFmt{space: true})
+		case "eol":
+			ch.fmts = append(ch.fmts, &TrimFmt{newline: true})
+		default:
+			log.Printf("-anyext: unknown formatter %q", name)
+		}
+	}
+	return ch
+}
+
+// newNameFmts returns the formatters for the files that are known by
+// their names rather than their extensions, keyed by base name or by
+// pattern, taken from fmts, those by extension.
+func newNameFmts(fmts map[string]Formatter) map[string]Formatter {
+	names := make(map[string]Formatter)
+	if mk, ok := fmts["mk"]; ok {
+		names["Makefile"] = mk
+		names["makefile"] = mk
+		names["GNUmakefile"] = mk
+	}
+	if df, ok := fmts["dockerfile"]; ok {
+		names["Dockerfile"] = df
+		names["Containerfile"] = df
+		names["Dockerfile.*"] = df
+	}
+	if gofmt, ok := fmts["go"]; ok {
+		if args := strings.Fields(*goTestCmd); len(args) > 0 {
+			names["*_test.go"] = &ChainFmt{[]Formatter{gofmt, &CmdFmt{args}}}
+		}
+	}
+	return names
+}
+
 func newFmts() map[string]Formatter {
-	gofmt := &GoImportFmt{cmd: "goimports"}
+	// goimports deletes the imports a file no longer uses, which gets
+	// in the way when code is commented out for a moment, only to add
+	// them back later. gofmt leaves imports alone: it neither removes
+	// the unused ones nor adds the missing ones.
+	gocmd := "goimports"
+	if *keepImports {
+		gocmd = "gofmt"
+	}
+	var gofmt Formatter = &GoImportFmt{cmd: gocmd, tags: *buildTags, local: *goLocal, mods: goMods}
+	if *goFumpt {
+		gofmt = &GofumptFmt{cmd: "gofumpt", tags: *buildTags}
+	}
 	pyfmt := &PyFmt{cmd: "yapf"}
 	rustfmt := &RustFmt{cmd: "fmtrust"}
-	defaultfmt := &DefaultEolFmt{cmd: "aeol"}
 	elmfmt := &ElmFmt{cmd: "elmfmt"}
 	fmts := make(map[string]Formatter)
 	fmts["py"] = pyfmt
 	fmts["go"] = gofmt
+	if *goFallback != "" {
+		fmts["go"] = &FallbackFmt{gofmt, &GoImportFmt{cmd: *goFallback, tags: *buildTags, local: *goLocal, mods: goMods}}
+	}
+	if rules, _ := parseRewriteRules(*goRewrite); len(rules) > 0 {
+		fmts["go"] = &ChainFmt{[]Formatter{fmts["go"], &GoRewriteFmt{cmd: "gofmt", rules: rules}}}
+	}
+	if *goTags {
+		fmts["go"] = &GoTagFmt{fmts["go"]}
+	}
 	fmts["rs"] = rustfmt
 	fmts["elm"] = elmfmt
-	fmts["anyext"] = defaultfmt
+	fmts["nix"] = newNixFmt(*nixCmd)
+	fmts["lua"] = &LuaFmt{cmd: "stylua", args: strings.Fields(*styluaArgs)}
+	fmts["ml"] = &OcamlFmt{cmd: "ocamlformat"}
+	fmts["mli"] = fmts["ml"]
+	fmts["tf"] = &HclFmt{cmd: *terraformCmd}
+	fmts["hcl"] = fmts["tf"]
+	fmts["jsonnet"] = &JsonnetFmt{cmd: "jsonnetfmt"}
+	fmts["libsonnet"] = fmts["jsonnet"]
+	fmts["cue"] = &CueFmt{cmd: "cue"}
+	fmts["dart"] = &DartFmt{cmd: "dart"}
+	fmts["swift"] = &SwiftFmt{cmd: "swift-format"}
+	fmts["s"] = &AsmFmt{cmd: "asmfmt"}
+	// rubocop is slow to start, and gets longer than other formatters.
+	rbTimeout := 30 * time.Second
+	if *cmdTimeout <= 0 || *cmdTimeout > rbTimeout {
+		rbTimeout = *cmdTimeout
+	}
+	fmts["rb"] = &RubyFmt{cmd: *rubyCmd, timeout: rbTimeout}
+	fmts["php"] = &PhpFmt{cmd: *phpCmd}
+	fmts["kt"] = &KotlinFmt{cmd: *kotlinCmd}
+	fmts["kts"] = fmts["kt"]
+	prettier := &PrettierFmt{cmd: *prettierCmd}
+	for _, ext := range []string{"js", "ts", "jsx", "tsx", "css"} {
+		fmts[ext] = prettier
+	}
+	fmts["md"] = &PrettierFmt{cmd: *prettierCmd, tidy: &MdFmt{width: *mdWidth}}
+	fmts["markdown"] = fmts["md"]
+	fmts["mk"] = &MakeFmt{}
+	fmts["dockerfile"] = &DockerfileFmt{cmd: *dockerCmd, lint: *hadolintCmd}
+	fmts["xml"] = &XmlFmt{indent: *xmlIndent}
+	fmts["csv"] = &CsvFmt{comma: ',', plain: *csvPlain}
+	fmts["tsv"] = &CsvFmt{comma: '\t', plain: *csvPlain}
+	fmts["anyext"] = newAnyextFmt(*anyextFmts)
+	configFmts(fmts, *fmtConfigFile)
+	lspFmts(fmts, *lspCmds)
+	embedFmts(fmts, *embedExts, *embedCmds)
+	if limits, err := extLimits(*jobLimits, "n"); err == nil {
+		limitJobs(fmts, limits)
+	}
 	return fmts
 }
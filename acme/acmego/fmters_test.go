@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countFmt is a Formatter that records how many formats run at once.
+type countFmt struct {
+	mu        sync.Mutex
+	running   int
+	maxActive int
+	release   chan struct{}
+}
+
+func (c *countFmt) format(file string) ([]byte, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.maxActive {
+		c.maxActive = c.running
+	}
+	c.mu.Unlock()
+	<-c.release
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return nil, nil
+}
+
+func TestLimitJobs(t *testing.T) {
+	c := &countFmt{release: make(chan struct{})}
+	fmts := map[string]Formatter{"rb": c, "go": &fakeFmt{}}
+	limitJobs(fmts, map[string]int64{"rb": 2, "go": 0, "kt": 1})
+	if _, ok := fmts["go"].(*fakeFmt); !ok {
+		t.Errorf("go formatter with no limit is %T, want *fakeFmt", fmts["go"])
+	}
+	if _, ok := fmts["kt"]; ok {
+		t.Errorf("limit added a formatter for kt")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fmts["rb"].format("file.rb")
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		c.release <- struct{}{}
+	}
+	wg.Wait()
+	if c.maxActive > 2 {
+		t.Errorf("%d formats ran at once, want at most 2", c.maxActive)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, test := range []struct {
+		out string
+		v   version
+		ok  bool
+	}{
+		{"1.56.3\n", version{1, 56, 3}, true},
+		{"ktlint version 0.48.2", version{0, 48, 2}, true},
+		{"stylua 0.20", version{0, 20, 0}, true},
+		{"Dart SDK version: 3.4.0 (stable)", version{3, 4, 0}, true},
+		{"unknown", version{}, false},
+	} {
+		v, ok := parseVersion([]byte(test.out))
+		if v != test.v || ok != test.ok {
+			t.Errorf("parseVersion(%q) = %v, %v, want %v, %v", test.out, v, ok, test.v, test.ok)
+		}
+	}
+	if !(version{0, 86, 9}).less(version{0, 87, 0}) || (version{1, 0, 0}).less(version{0, 87, 0}) {
+		t.Errorf("version.less is wrong")
+	}
+}
+
+func TestParseRewriteRules(t *testing.T) {
+	rules, err := parseRewriteRules(" interface{} -> any ;; a[b:len(a)] -> a[b:] ")
+	if want := []string{"interface{} -> any", "a[b:len(a)] -> a[b:]"}; err != nil || !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %q, %v, want %q", rules, err, want)
+	}
+	for _, s := range []string{"interface{}", "a -> b -> c", "a( -> b"} {
+		if _, err := parseRewriteRules(s); err == nil {
+			t.Errorf("parseRewriteRules(%q) succeeded", s)
+		}
+	}
+}
+
+func TestGoRewriteFmt(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "x.go")
+	src := "package p\n\nvar v interface{} = foo(s[1:len(s)])\n"
+	if err := ioutil.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := "package p\n\nvar v any = baz(s[1:])\n"
+	// The second rule makes work for the first, which the next pass does.
+	g := &GoRewriteFmt{cmd: "gofmt", rules: []string{"bar(x) -> baz(x)", "foo(x) -> bar(x)", "interface{} -> any", "a[b:len(a)] -> a[b:]"}}
+	new, err := g.format(file)
+	if err != nil || string(new) != want {
+		t.Fatalf("format = %q, %v, want %q", new, err, want)
+	}
+	if err := ioutil.WriteFile(file, new, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := g.format(file); err != nil || string(again) != want {
+		t.Errorf("formatting again = %q, %v, want %q", again, err, want)
+	}
+
+	errf := errorf
+	defer func() { errorf = errf }()
+	errorf = func(string, string, ...interface{}) {}
+	g.rules = []string{"foo(x) -> foo(foo(x))"}
+	if _, err := g.format(file); err != nil {
+		t.Errorf("rule matching nothing: %v", err)
+	}
+	ioutil.WriteFile(file, []byte(src), 0666)
+	if _, err := g.format(file); err == nil {
+		t.Errorf("rule growing the code at every pass settled")
+	}
+}
+
+// TestGofumptFmt runs GofumptFmt with a stand-in for gofumpt that
+// prints the file as it is, or fails on one that does not parse.
+func TestGofumptFmt(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd := filepath.Join(dir, "gofumpt")
+	script := "#!/bin/sh\ngrep -q '^package ' \"$1\" || { echo \"$1:1:1: expected 'package'\" >&2; exit 2; }\ncat \"$1\"\n"
+	if err := ioutil.WriteFile(cmd, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "x.go")
+	src := []byte("package p\n\nvar v = 1\n")
+	if err := ioutil.WriteFile(file, src, 0666); err != nil {
+		t.Fatal(err)
+	}
+	g := &GofumptFmt{cmd: cmd}
+	new, err := g.format(file)
+	if err != nil || !bytes.Equal(new, src) {
+		t.Fatalf("format with no changes = %q, %v, want %q", new, err, src)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("var v = 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	new, err = g.format(file)
+	if err == nil || !bytes.Contains(new, []byte("expected 'package'")) {
+		t.Errorf("format with a syntax error = %q, %v, want the error of gofumpt", new, err)
+	}
+}
+
+// TestPrettierFmt runs PrettierFmt with a stand-in for prettier that
+// upper-cases its input, or fails with a message on standard error for
+// a file name with "bad" in it.
+func TestPrettierFmt(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd := filepath.Join(dir, "prettier")
+	script := "#!/bin/sh\ncase \"$1 $2\" in\n--stdin-filepath\\ *bad*) echo \"[error] $2: SyntaxError\" >&2; exit 2;;\n--stdin-filepath\\ *) pwd >\"$2.dir\"; tr a-z A-Z;;\n*) exit 1;;\nesac\n"
+	if err := ioutil.WriteFile(cmd, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "src")
+	if err := os.Mkdir(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "x.js")
+	if err := ioutil.WriteFile(file, []byte("let x = 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pf := &PrettierFmt{cmd: cmd}
+	new, err := pf.format(file)
+	if err != nil || string(new) != "LET X = 1\n" {
+		t.Fatalf("format = %q, %v, want %q", new, err, "LET X = 1\n")
+	}
+	if wd, _ := ioutil.ReadFile(file + ".dir"); string(wd) != sub+"\n" {
+		t.Errorf("prettier ran in %q, want %q", wd, sub)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte("{}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pf.format(file); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := ioutil.ReadFile(file + ".dir"); string(wd) != dir+"\n" {
+		t.Errorf("prettier ran in %q, want the project's %q", wd, dir)
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != "let x = 1\n" {
+		t.Errorf("file rewritten in place: %q", data)
+	}
+
+	var reported string
+	errf := errorf
+	defer func() { errorf = errf }()
+	errorf = func(file, format string, args ...interface{}) { reported = fmt.Sprintf(format, args...) }
+	bad := filepath.Join(sub, "bad.js")
+	if err := ioutil.WriteFile(bad, []byte("let\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pf.format(bad); err == nil || !strings.Contains(reported, "SyntaxError") {
+		t.Errorf("format of bad file = %v, reported %q, want prettier's error", err, reported)
+	}
+
+	md := filepath.Join(sub, "x.md")
+	if err := ioutil.WriteFile(md, []byte("text\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pf = &PrettierFmt{cmd: filepath.Join(dir, "missing"), tidy: upperFmt{}}
+	if new, err := pf.format(md); err != nil || string(new) != "TEXT\n" {
+		t.Errorf("format with no prettier = %q, %v, want the tidied file", new, err)
+	}
+}
+
+func TestWaitCmdTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	// The shell leaves a sleep of its own behind, holding the output
+	// open, which only killing the process group ends.
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	start := time.Now()
+	out, err := runCmdTimeout(cmd, 100*time.Millisecond)
+	if _, ok := err.(*timeoutError); !ok {
+		t.Fatalf("runCmd = %q, %v, want a timeout", out, err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("command killed after %v", d)
+	}
+	if cmd.ProcessState == nil {
+		t.Errorf("command not reaped")
+	}
+
+	cmd = exec.Command("sh", "-c", "echo ok")
+	if out, err := runCmdTimeout(cmd, 10*time.Second); err != nil || string(out) != "ok\n" {
+		t.Errorf("quick command = %q, %v, want %q", out, err, "ok\n")
+	}
+}
+
+// runCmdTimeout runs cmd as runCmd does, with the -timeout set to d.
+func runCmdTimeout(cmd *exec.Cmd, d time.Duration) ([]byte, error) {
+	defer func(d time.Duration) { *cmdTimeout = d }(*cmdTimeout)
+	*cmdTimeout = d
+	return runCmd(cmd, "file")
+}
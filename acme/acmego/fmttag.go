@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+)
+
+// tagOps are the acme log ops after which a window is given a Fmt
+// command: those that create or name it, or bring a window that
+// existed before acmego started to its attention.
+var tagOps = map[string]bool{"new": true, "zerox": true, "get": true, "put": true, "focus": true}
+
+// A tagger puts a Fmt command in the tags of windows and takes over
+// their events to catch its execution, which it passes on as a
+// request to format the window. FmtDot, typed into the tag of a Go
+// window, is passed on the same way to format the code at the dot.
+// All other events go back to acme to handle. A window is dropped
+// when it is deleted, its events file with it.
+type tagger struct {
+	reqs chan fmtRequest
+
+	mu   sync.Mutex
+	wins map[int]bool // windows being watched
+}
+
+// A fmtRequest asks for window id, showing file name, to be formatted,
+// or only the Go code at its dot if dot is set.
+type fmtRequest struct {
+	id   int
+	name string
+	dot  bool
+}
+
+func newTagger() *tagger {
+	return &tagger{reqs: make(chan fmtRequest), wins: make(map[int]bool)}
+}
+
+// add starts watching window id for Fmt, adding the command to its
+// tag unless it is already there.
+func (t *tagger) add(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.wins[id] {
+		return
+	}
+	w, err := acme.Open(id, nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	tag, err := w.ReadAll("tag")
+	if err != nil {
+		log.Print(err)
+		w.CloseFiles()
+		return
+	}
+	if !hasWord(string(tag), "Fmt") {
+		w.Write("tag", []byte(" Fmt"))
+	}
+	t.wins[id] = true
+	go func() {
+		w.EventLoop(&fmtHandler{t, w})
+		t.mu.Lock()
+		delete(t.wins, id)
+		t.mu.Unlock()
+		w.CloseFiles()
+	}()
+}
+
+// hasWord reports whether the tag text holds word among the words
+// after the file name.
+func hasWord(tag, word string) bool {
+	f := strings.Fields(tag)
+	for i := 1; i < len(f); i++ {
+		if f[i] == word {
+			return true
+		}
+	}
+	return false
+}
+
+// A fmtHandler handles the events of a window the tagger watches.
+type fmtHandler struct {
+	t *tagger
+	w *acme.Win
+}
+
+func (h *fmtHandler) Execute(cmd string) bool { return false }
+func (h *fmtHandler) Look(arg string) bool    { return false }
+
+func (h *fmtHandler) ExecFmt()    { h.request(false) }
+func (h *fmtHandler) ExecFmtDot() { h.request(true) }
+
+func (h *fmtHandler) request(dot bool) {
+	// The window may have been renamed since it was added.
+	tag, err := h.w.ReadAll("tag")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	f := strings.Fields(string(tag))
+	if len(f) == 0 {
+		return
+	}
+	h.t.reqs <- fmtRequest{h.w.ID(), f[0], dot}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// isGenerated reports whether src is marked as generated by a line
+// matching marker, as Go files are with
+//
+//	// Code generated by stringer; DO NOT EDIT.
+//
+// The marker must be in the comments at the top of the file, before
+// the first line that is neither blank nor, by the comment syntax of
+// common languages, a comment.
+func isGenerated(src []byte, marker *regexp.Regexp) bool {
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if marker.MatchString(line) {
+			return true
+		}
+		if line != "" && !isComment(line) {
+			return false
+		}
+	}
+	return false
+}
+
+// isComment reports whether line starts like a comment.
+func isComment(line string) bool {
+	for _, p := range []string{"//", "#", "--", ";", "/*", "*", "<!--", "(*", "%"} {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// changedLines returns the line ranges of file that differ from the
+// version committed to git, as pairs of first and last line. It is
+// used to restrict formatting to the lines someone actually touched,
+// the way darker does for Python: the whole file is formatted and
+// only the edits that overlap a changed range are applied, so it
+// works for any formatter. The result is false if file is not
+// tracked by git, in which case the whole file should be formatted.
+func changedLines(file string) ([][2]int, bool) {
+	dir, base := filepath.Split(file)
+	cmd := exec.Command("git", "ls-files", "--error-unmatch", "--", base)
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return nil, false
+	}
+	cmd = exec.Command("git", "diff", "--no-color", "--no-ext-diff", "-U0", "HEAD", "--", base)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+	var ranges [][2]int
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		// @@ -12,3 +14,5 @@ context
+		if !bytes.HasPrefix(line, []byte("@@ -")) {
+			continue
+		}
+		f := strings.Fields(string(line))
+		if len(f) < 3 || !strings.HasPrefix(f[2], "+") {
+			continue
+		}
+		start, count := f[2][1:], "1"
+		if i := strings.Index(start, ","); i >= 0 {
+			start, count = start[:i], start[i+1:]
+		}
+		s, err1 := strconv.Atoi(start)
+		n, err2 := strconv.Atoi(count)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if n == 0 {
+			// Pure deletion after line s: the lines around it changed.
+			ranges = append(ranges, [2]int{s, s + 1})
+			continue
+		}
+		ranges = append(ranges, [2]int{s, s + n - 1})
+	}
+	return ranges, true
+}
+
+// editsInRanges returns the edits that touch one of the line ranges.
+// Edits not tied to particular lines are always kept.
+func editsInRanges(edits []edit, ranges [][2]int) []edit {
+	var keep []edit
+	for _, e := range edits {
+		if e.end == 0 {
+			keep = append(keep, e)
+			continue
+		}
+		for _, r := range ranges {
+			if e.start <= r[1] && e.end >= r[0] {
+				keep = append(keep, e)
+				break
+			}
+		}
+	}
+	return keep
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"unicode/utf8"
+)
+
+// formatDot formats the Go code at the dot of window id, showing file
+// name, leaving the rest of the body alone. The dot is first widened
+// to the smallest statement or declaration around it, unless it is
+// exactly an expression larger than a word, so that there is a
+// complete piece of code to format. If the body cannot be parsed the
+// dot is formatted as it is. Code that is not a complete expression,
+// statement or declaration is reported in the +Errors window and left
+// unchanged.
+func formatDot(id int, name string) {
+	win, err := openWin(id)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	w := Window{win, false}
+	defer w.CloseFiles()
+	// Open the addr file first, so that addr=dot holds for the read.
+	w.ReadAddr()
+	if err := w.Ctl("addr=dot"); err != nil {
+		log.Print(err)
+		return
+	}
+	q0, q1, err := w.ReadAddr()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	body, err := w.ReadAll("body")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	b0, b1 := byteOffset(body, q0), byteOffset(body, q1)
+	if s0, s1, ok := expandDot(body, b0, b1); ok {
+		b0, b1 = s0, s1
+	}
+	out, err := formatSnippet(body[b0:b1], lineIndent(body, b0))
+	if err != nil {
+		errorf(name, "acmego: %s:#%d,#%d: %v", name, q0, q1, err)
+		return
+	}
+	if bytes.Equal(out, body[b0:b1]) {
+		return
+	}
+	q0, q1 = utf8.RuneCount(body[:b0]), utf8.RuneCount(body[:b1])
+	if *dryRun {
+		log.Printf("%s: would format #%d,#%d", name, q0, q1)
+		return
+	}
+	w.apply([]edit{{addr: fmt.Sprintf("#%d,#%d", q0, q1), data: out}})
+	infof("formatted %s:#%d,#%d", name, q0, q1)
+}
+
+// byteOffset returns the offset in bytes of rune offset q in text.
+func byteOffset(text []byte, q int) int {
+	i := 0
+	for ; q > 0 && i < len(text); q-- {
+		_, size := utf8.DecodeRune(text[i:])
+		i += size
+	}
+	return i
+}
+
+// lineIndent returns the number of tabs that start the line holding
+// offset i of text.
+func lineIndent(text []byte, i int) int {
+	i = bytes.LastIndexByte(text[:i], '\n') + 1
+	n := 0
+	for i+n < len(text) && text[i+n] == '\t' {
+		n++
+	}
+	return n
+}
+
+// expandDot returns the byte range of src to format for the dot at
+// b0, b1: the dot itself if it is exactly an expression other than a
+// name or literal, or else the smallest statement or declaration
+// holding it. It reports false if src does not parse or no such node
+// holds the dot.
+func expandDot(src []byte, b0, b1 int) (int, int, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return 0, 0, false
+	}
+	tf := fset.File(f.Pos())
+	s0, s1, ok := 0, 0, false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		start, end := tf.Offset(n.Pos()), tf.Offset(n.End())
+		if b0 < start || end < b1 {
+			return false
+		}
+		switch n.(type) {
+		case *ast.CaseClause, *ast.CommClause:
+			// Not a statement on its own.
+		case *ast.Ident, *ast.BasicLit:
+			// Nothing to format; a word selected in acme is
+			// taken to be in the code around it.
+		case ast.Expr:
+			if start == b0 && end == b1 {
+				s0, s1, ok = start, end, true
+				return false
+			}
+		case ast.Stmt, ast.Decl:
+			s0, s1, ok = start, end, true
+		}
+		return true
+	})
+	return s0, s1, ok
+}
+
+// formatSnippet formats src, a Go expression, declarations or
+// statements, as gofmt would at indent tabs into a line. The first
+// line of the result is not indented, as it continues the line src
+// starts in. Statements are formatted in a function, so they are
+// indented by at least one tab.
+func formatSnippet(src []byte, indent int) ([]byte, error) {
+	text := bytes.TrimRight(src, " \t\n")
+	fset := token.NewFileSet()
+	parse := func(prefix, suffix string) (*ast.File, error) {
+		code := append(append([]byte(prefix), text...), suffix...)
+		return parser.ParseFile(fset, "", code, parser.ParseComments)
+	}
+	var node interface{}
+	var header []byte // what is printed before the snippet
+	stmts := false
+	if f, err := parse("package p; var _ = ", ""); err == nil && len(f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values) == 1 {
+		node = &printer.CommentedNode{Node: f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0], Comments: f.Comments}
+	} else if f, err := parse("package p\n", ""); err == nil && len(f.Decls) > 0 {
+		node, header = f, []byte("package p\n\n")
+	} else if f, err := parse("package p; func _() {\n", "\n}"); err == nil {
+		// Print the whole function, one tab out, and cut the
+		// statements from it, so that the comments between
+		// them are kept.
+		node, header, stmts = f, []byte("func _() {\n"), true
+		if indent--; indent < 0 {
+			indent = 0
+		}
+	} else {
+		return nil, errors.New("not a complete Go expression, declaration or statement")
+	}
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8, Indent: indent}
+	if err := cfg.Fprint(&buf, fset, node); err != nil {
+		return nil, err
+	}
+	out := bytes.TrimRight(buf.Bytes(), "\n")
+	if i := bytes.Index(out, header); i >= 0 {
+		out = out[i+len(header):]
+	}
+	if stmts {
+		// Drop the closing brace.
+		out = out[:bytes.LastIndexByte(out, '\n')]
+	}
+	out = bytes.TrimLeft(out, "\t")
+	return append(out, src[len(text):]...), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var snippetTests = []struct {
+	src    string
+	indent int
+	out    string
+}{
+	{"a+b *  c", 0, "a + b*c"},
+	{"x:=f( 1 )\n", 1, "x := f(1)\n"},
+	{"if x {\ny()\n}", 1, "if x {\n\t\ty()\n\t}"},
+	{"func f( ) { // c\nreturn}", 0, "func f() { // c\n\treturn\n}"},
+	{"var x=1\n// c\ntype t int", 0, "var x = 1\n\n// c\ntype t int"},
+	{"x:=1\n\n// c\ny( )", 2, "x := 1\n\n\t\t// c\n\t\ty()"},
+	{"s := `a\nb`\nif x {\ny()\n}", 1, "s := `a\nb`\n\tif x {\n\t\ty()\n\t}"},
+}
+
+func TestFormatSnippet(t *testing.T) {
+	for _, test := range snippetTests {
+		out, err := formatSnippet([]byte(test.src), test.indent)
+		if err != nil || string(out) != test.out {
+			t.Errorf("formatSnippet(%q, %d) = %q, %v, want %q", test.src, test.indent, out, err, test.out)
+		}
+	}
+	for _, src := range []string{"a +", "if x {", "case 1:"} {
+		if out, err := formatSnippet([]byte(src), 0); err == nil {
+			t.Errorf("formatSnippet(%q) = %q, want error", src, out)
+		}
+	}
+}
+
+func TestExpandDot(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\tx := a+b\n\tg(x)\n}\n"
+	tests := []struct {
+		at   string // the text that ends with the dot
+		dot  string
+		want string // the text the dot expands to
+	}{
+		{"a+b", "a+b", "a+b"},
+		{"x := a", "a", "x := a+b"}, // a word is taken as the code around it
+		{"a+b", "+b", "x := a+b"},
+		{"\tg", "g", "g(x)"},
+		{"a+b\n\tg", "a+b\n\tg", "{\n\tx := a+b\n\tg(x)\n}"},
+		{"func", "func", "func f() {\n\tx := a+b\n\tg(x)\n}"},
+	}
+	for _, test := range tests {
+		b1 := strings.Index(src, test.at) + len(test.at)
+		b0 := b1 - len(test.dot)
+		s0, s1, ok := expandDot([]byte(src), b0, b1)
+		if !ok || src[s0:s1] != test.want {
+			t.Errorf("expandDot(%q) = %q, %v, want %q", test.dot, src[s0:s1], ok, test.want)
+		}
+	}
+	if _, _, ok := expandDot([]byte("package p\n\nfunc {"), 10, 11); ok {
+		t.Errorf("expandDot of a broken file succeeded")
+	}
+}
+
+// TestFormatDot checks that the dot is replaced in a single undo step.
+func TestFormatDot(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\tx := a+b\n}\n"
+	i := strings.Index(src, "a+b")
+	w := &fakeWin{body: []byte(src), dot: &[2]int{i, i + 3}}
+	withFakeWin(w, "", func() {
+		formatDot(1, "/src/p.go")
+	})
+	want := []string{"ctl addr=dot", "ctl mark", "ctl nomark", "addr #28,#31", "data a + b"}
+	if !reflect.DeepEqual(w.ops, want) || !w.closed {
+		t.Errorf("ops = %q, closed %v, want %q, closed", w.ops, w.closed, want)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goMods caches module paths across reloads of the formatters.
+var goMods = &modCache{dirs: make(map[string]modInfo)}
+
+// A modCache finds the path of the module a directory belongs to, as
+// declared in the nearest go.mod above it. The go.mod file found for a
+// directory is remembered, and read again only when it changes.
+type modCache struct {
+	mu   sync.Mutex
+	dirs map[string]modInfo
+}
+
+type modInfo struct {
+	gomod string    // go.mod file, or "" if there is none
+	mtime time.Time // modification time of gomod when path was read
+	path  string    // module path
+}
+
+// modulePath returns the module path for files in dir, or the empty
+// string if they are in no module.
+func (c *modCache) modulePath(dir string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.dirs[dir]
+	if !ok {
+		info.gomod = findGoMod(dir)
+	}
+	if info.gomod == "" {
+		c.dirs[dir] = info
+		return ""
+	}
+	fi, err := os.Stat(info.gomod)
+	if err != nil {
+		// Gone; look again next time.
+		delete(c.dirs, dir)
+		return ""
+	}
+	if !ok || !fi.ModTime().Equal(info.mtime) {
+		info.mtime = fi.ModTime()
+		info.path = readModulePath(info.gomod)
+	}
+	c.dirs[dir] = info
+	return info.path
+}
+
+// findGoMod returns the go.mod file in dir or the nearest of its
+// parents, or "" if there is none.
+func findGoMod(dir string) string {
+	return findUp(dir, goMarkers)
+}
+
+// readModulePath returns the path in the module directive of the
+// go.mod file, or "" if it cannot be read.
+func readModulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		line = strings.TrimSpace(line[len("module"):])
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if p, err := strconv.Unquote(line); err == nil {
+			return p
+		}
+		return line
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// GoTagFmt runs the Go formatter base and then aligns the key:"value"
+// pairs of struct field tags into columns, something gofmt does not do,
+// so that
+//
+//	Name string `json:"name" db:"n"`
+//	ID   int    `json:"id,omitempty" db:"id"`
+//
+// becomes
+//
+//	Name string `json:"name"         db:"n"`
+//	ID   int    `json:"id,omitempty" db:"id"`
+//
+// Tags are aligned within runs of fields on consecutive lines, the same
+// runs gofmt aligns the tags themselves in; embedded fields make runs
+// of their own. The result is formatted again; aligning aligned tags
+// changes nothing.
+type GoTagFmt struct {
+	base Formatter
+}
+
+func (g *GoTagFmt) format(file string) ([]byte, error) {
+	src, err := g.base.format(file)
+	if err != nil {
+		return src, err
+	}
+	new, err := alignTags(src)
+	if err != nil {
+		// The base formatter accepted the file, so this should not
+		// happen; keep its result rather than failing.
+		return src, nil
+	}
+	return new, nil
+}
+
+// alignTags aligns the struct tags in the Go source src.
+func alignTags(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		st, ok := n.(*ast.StructType)
+		if !ok {
+			return true
+		}
+		var run []*ast.BasicLit
+		prevLine := -1
+		prevEmbedded := false
+		for _, field := range st.Fields.List {
+			line := fset.Position(field.Pos()).Line
+			// gofmt puts the tag of an embedded field in the column
+			// of the types of the others, so the two kinds of field
+			// do not share the column their tags start in.
+			embedded := len(field.Names) == 0
+			if field.Tag == nil || line != prevLine+1 || fset.Position(field.End()).Line != line || embedded != prevEmbedded {
+				changed = alignRun(run) || changed
+				run = nil
+			}
+			if field.Tag != nil {
+				run = append(run, field.Tag)
+			}
+			prevLine = fset.Position(field.End()).Line
+			prevEmbedded = embedded
+		}
+		changed = alignRun(run) || changed
+		return true
+	})
+	if !changed {
+		return src, nil
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// alignRun pads the key:"value" pairs of the raw string tags in run so
+// that the pairs at the same position start in the same column.
+// It reports whether any tag changed.
+func alignRun(run []*ast.BasicLit) bool {
+	if len(run) < 2 {
+		return false
+	}
+	pairs := make([][]string, len(run))
+	var widths []int
+	for i, tag := range run {
+		if !strings.HasPrefix(tag.Value, "`") {
+			continue
+		}
+		p, ok := tagPairs(tag.Value[1 : len(tag.Value)-1])
+		if !ok {
+			continue
+		}
+		pairs[i] = p
+		for j, pair := range p {
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(pair); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+	changed := false
+	for i, tag := range run {
+		if pairs[i] == nil {
+			continue
+		}
+		var b strings.Builder
+		b.WriteByte('`')
+		for j, pair := range pairs[i] {
+			b.WriteString(pair)
+			if j < len(pairs[i])-1 {
+				b.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(pair)+1))
+			}
+		}
+		b.WriteByte('`')
+		if v := b.String(); v != tag.Value {
+			tag.Value = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// tagPairs splits a struct tag into its key:"value" pairs, following
+// the conventions of reflect.StructTag. It reports false if the tag
+// does not follow them.
+func tagPairs(tag string) ([]string, bool) {
+	var pairs []string
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return pairs, len(pairs) > 0
+		}
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, false
+		}
+		j := i + 2
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			return nil, false
+		}
+		if _, err := strconv.Unquote(tag[i+1 : j+1]); err != nil {
+			return nil, false
+		}
+		pairs = append(pairs, tag[:j+1])
+		tag = tag[j+1:]
+	}
+}
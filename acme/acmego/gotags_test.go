@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+var alignTagsTests = []struct {
+	name     string
+	src, out string
+}{
+	{
+		name: "consecutive fields",
+		src:  "package p\n\ntype T struct {\n\tName string `json:\"name\" db:\"n\"`\n\tID   int    `json:\"id,omitempty\" db:\"id\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tName string `json:\"name\"         db:\"n\"`\n\tID   int    `json:\"id,omitempty\" db:\"id\"`\n}\n",
+	},
+	{
+		name: "groups broken by blank lines and comments",
+		src:  "package p\n\ntype T struct {\n\tA int `json:\"a\" db:\"a\"`\n\n\tLonger int `json:\"longer\" db:\"l\"`\n\tB      int `json:\"b\" db:\"b\"`\n\t// C is commented.\n\tC int `json:\"cccc\" db:\"c\"`\n\tD int `json:\"d\" db:\"d\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tA int `json:\"a\" db:\"a\"`\n\n\tLonger int `json:\"longer\" db:\"l\"`\n\tB      int `json:\"b\"      db:\"b\"`\n\t// C is commented.\n\tC int `json:\"cccc\" db:\"c\"`\n\tD int `json:\"d\"    db:\"d\"`\n}\n",
+	},
+	{
+		name: "field without a tag",
+		src:  "package p\n\ntype T struct {\n\tA    int `json:\"aaaa\" db:\"a\"`\n\tNone int\n\tB    int `json:\"b\" db:\"b\"`\n\tC    int `json:\"ccc\" db:\"cc\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tA    int `json:\"aaaa\" db:\"a\"`\n\tNone int\n\tB    int `json:\"b\"   db:\"b\"`\n\tC    int `json:\"ccc\" db:\"cc\"`\n}\n",
+	},
+	{
+		name: "embedded fields",
+		src:  "package p\n\ntype T struct {\n\tEmbedded `json:\"embedded\" db:\"e\"`\n\t*Ptr     `json:\"p\" db:\"p\"`\n\tX        int `json:\"x\" db:\"x\"`\n\tYy       int `json:\"yy,omitempty\" db:\"y\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tEmbedded `json:\"embedded\" db:\"e\"`\n\t*Ptr     `json:\"p\"        db:\"p\"`\n\tX        int `json:\"x\"            db:\"x\"`\n\tYy       int `json:\"yy,omitempty\" db:\"y\"`\n}\n",
+	},
+	{
+		name: "already aligned",
+		src:  "package p\n\ntype T struct {\n\tA int `json:\"a\"  db:\"a\"`\n\tB int `json:\"bb\" db:\"b\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tA int `json:\"a\"  db:\"a\"`\n\tB int `json:\"bb\" db:\"b\"`\n}\n",
+	},
+	{
+		name: "tag not in key:value form",
+		src:  "package p\n\ntype T struct {\n\tA int `free text`\n\tB int `json:\"b\" db:\"b\"`\n}\n",
+		out:  "package p\n\ntype T struct {\n\tA int `free text`\n\tB int `json:\"b\" db:\"b\"`\n}\n",
+	},
+}
+
+func TestAlignTags(t *testing.T) {
+	for _, test := range alignTagsTests {
+		out, err := alignTags([]byte(test.src))
+		if err != nil || string(out) != test.out {
+			t.Errorf("%s: alignTags = %q, %v, want %q", test.name, out, err, test.out)
+			continue
+		}
+		if again, err := alignTags(out); err != nil || string(again) != string(out) {
+			t.Errorf("%s: aligning again = %q, %v, want it unchanged", test.name, again, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Hook runs an external command on a file after it has been formatted.
+// Runs for the same file are throttled to at most one per interval and
+// happen in the background so that they do not hold up the event loop.
+// Any output of the command is shown in the +Errors window.
+type Hook struct {
+	cmd      string
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time // file -> time of the last run
+}
+
+func newHook(cmd string, interval time.Duration) *Hook {
+	return &Hook{cmd: cmd, interval: interval, last: make(map[string]time.Time)}
+}
+
+// run starts the hook command on file unless it already ran for file
+// within the last interval.
+func (h *Hook) run(file string) {
+	now := time.Now()
+	h.mu.Lock()
+	if t, ok := h.last[file]; ok && now.Sub(t) < h.interval {
+		h.mu.Unlock()
+		return
+	}
+	h.last[file] = now
+	// Forget about files whose interval has expired so the map
+	// does not grow for the lifetime of the process.
+	for f, t := range h.last {
+		if now.Sub(t) >= h.interval {
+			delete(h.last, f)
+		}
+	}
+	h.mu.Unlock()
+
+	go func() {
+		out, err := exec.Command(h.cmd, file).CombinedOutput()
+		msg := strings.TrimSpace(string(out))
+		if err != nil {
+			msg = strings.TrimSpace(h.cmd + " " + file + ": " + err.Error() + "\n" + msg)
+		}
+		if msg != "" {
+			errorf(file, "%s", msg)
+		}
+	}()
+}
+
+// A BatchHook runs an external command once a burst of formatting is
+// over, when no file has been formatted for delay, rather than after
+// every file as a Hook does. It runs in the directory of the last file
+// formatted, in the background, and its output is shown in the +Errors
+// window of that directory.
+type BatchHook struct {
+	args  []string
+	delay time.Duration
+
+	mu    sync.Mutex
+	last  string // file formatted last
+	timer *time.Timer
+}
+
+func newBatchHook(cmd string, delay time.Duration) *BatchHook {
+	return &BatchHook{args: strings.Fields(cmd), delay: delay}
+}
+
+// formatted notes that file has been formatted, putting the run of the
+// command off until delay from now.
+func (h *BatchHook) formatted(file string) {
+	if len(h.args) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = file
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.timer = time.AfterFunc(h.delay, h.run)
+}
+
+func (h *BatchHook) run() {
+	h.mu.Lock()
+	file := h.last
+	h.mu.Unlock()
+	cmd := exec.Command(h.args[0], h.args[1:]...)
+	cmd.Dir = filepath.Dir(file)
+	out, err := cmd.CombinedOutput()
+	msg := strings.TrimSpace(string(out))
+	if err != nil {
+		msg = strings.TrimSpace(strings.Join(h.args, " ") + ": " + err.Error() + "\n" + msg)
+	}
+	if msg != "" {
+		errorf(file, "%s", msg)
+	}
+}
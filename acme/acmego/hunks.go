@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+)
+
+// pickers holds the windows showing the hunks of each file to pick
+// from, so that a new formatting of the file replaces its window.
+var pickers = struct {
+	sync.Mutex
+	m map[string]*acme.Win
+}{m: make(map[string]*acme.Win)}
+
+// pickEdits shows the edits that format file, shown in window id
+// holding old, as numbered hunks in a window of their own named
+// file+"+hunks", instead of applying them. The user deletes the
+// hunks not wanted from that window and executes Apply there, which
+// applies those left, as git add -p would stage them.
+func pickEdits(id int, file string, old []byte, edits []edit) {
+	pickers.Lock()
+	defer pickers.Unlock()
+	if w := pickers.m[file]; w != nil {
+		// Its hunks are stale.
+		w.Ctl("delete")
+	}
+	w, err := acme.New()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	w.Name("%s+hunks", file)
+	w.Fprintf("tag", " Apply")
+	w.Write("body", hunkText(old, edits))
+	w.Addr("#0")
+	w.Ctl("dot=addr")
+	w.Ctl("clean")
+	w.Ctl("show")
+	pickers.m[file] = w
+	go func() {
+		w.EventLoop(&hunkPicker{id, file, old, edits, w})
+		pickers.Lock()
+		if pickers.m[file] == w {
+			delete(pickers.m, file)
+		}
+		pickers.Unlock()
+		w.CloseFiles()
+	}()
+}
+
+// hunkText returns the text that shows edits, which turn old into the
+// formatted text, as hunks numbered from 1 in the order they come in
+// the file, each a header line starting with @@ followed by the lines
+// it removes, marked with -, and those it adds, marked with +.
+func hunkText(old []byte, edits []edit) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Delete the hunks not to apply, then execute Apply; Del to apply none.\n")
+	for n := 1; n <= len(edits); n++ {
+		e := edits[len(edits)-n]
+		var removed []byte
+		switch {
+		case e.addr == "$":
+			fmt.Fprintf(&buf, "@@ %d @@ end of file\n", n)
+		case e.addr == "#0":
+			fmt.Fprintf(&buf, "@@ %d @@ start of file\n", n)
+		case strings.HasSuffix(e.addr, "#0"):
+			fmt.Fprintf(&buf, "@@ %d @@ after line %d\n", n, e.start)
+		default:
+			fmt.Fprintf(&buf, "@@ %d @@ lines %d,%d\n", n, e.start, e.end)
+			removed = findLines(old, e.start, e.end)
+		}
+		markLines(&buf, "-", removed)
+		if e.addr == "$" {
+			buf.WriteString("+(final newline)\n")
+		} else {
+			markLines(&buf, "+", e.data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// markLines writes the lines of text to buf, each after mark.
+func markLines(buf *bytes.Buffer, mark string, text []byte) {
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		if line == "" {
+			continue
+		}
+		buf.WriteString(mark + strings.TrimSuffix(line, "\n") + "\n")
+	}
+}
+
+// pickedHunks returns the numbers of the hunks whose headers are in
+// text, as written by hunkText.
+func pickedHunks(text []byte) map[int]bool {
+	picked := make(map[int]bool)
+	for _, line := range strings.Split(string(text), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 || f[0] != "@@" || f[2] != "@@" {
+			continue
+		}
+		if n, err := strconv.Atoi(f[1]); err == nil {
+			picked[n] = true
+		}
+	}
+	return picked
+}
+
+// A hunkPicker handles the events of the window showing the hunks of
+// file, in window id, to pick from.
+type hunkPicker struct {
+	id    int
+	file  string
+	old   []byte
+	edits []edit
+	w     *acme.Win
+}
+
+func (p *hunkPicker) Execute(cmd string) bool { return false }
+func (p *hunkPicker) Look(arg string) bool    { return false }
+
+// ExecApply applies the hunks left in the window and deletes it.
+func (p *hunkPicker) ExecApply() error {
+	text, err := p.w.ReadAll("body")
+	if err != nil {
+		return err
+	}
+	picked := pickedHunks(text)
+	var edits []edit
+	for i, e := range p.edits {
+		if picked[len(p.edits)-i] {
+			edits = append(edits, e)
+		}
+	}
+	win, err := openWin(p.id)
+	if err != nil {
+		return err
+	}
+	w := Window{win, false}
+	defer w.CloseFiles()
+	body, err := w.ReadAll("body")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(body, p.old) {
+		return fmt.Errorf("%s: window modified since the hunks were shown; format it again", p.file)
+	}
+	if len(edits) > 0 {
+		saved.save(p.file, p.old)
+		w.apply(edits)
+	}
+	infof("formatted %s: %d of %d edits", p.file, len(edits), len(p.edits))
+	p.w.Ctl("delete")
+	return nil
+}
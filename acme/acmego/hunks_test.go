@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHunkText(t *testing.T) {
+	old := []byte("a\nb\nc\nd")
+	new := []byte("x\na\nB\nc\nd\n")
+	edits, err := computeEdits([]byte("0a1\n> x\n2c3\n< b\n---\n> B\n4c5\n< d\n\\ No newline at end of file\n---\n> d\n"), old, new, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := hunkText(old, edits)
+	want := "Delete the hunks not to apply, then execute Apply; Del to apply none.\n" +
+		"@@ 1 @@ start of file\n+x\n" +
+		"@@ 2 @@ lines 2,2\n-b\n+B\n" +
+		"@@ 3 @@ lines 4,4\n-d\n+d\n" +
+		"@@ 4 @@ end of file\n+(final newline)\n"
+	if string(text) != want {
+		t.Errorf("hunkText:\n%s\nwant:\n%s", text, want)
+	}
+	// Delete hunk 2, as the user would.
+	i := strings.Index(string(text), "@@ 2")
+	j := strings.Index(string(text), "@@ 3")
+	picked := pickedHunks(append(text[:i:i], text[j:]...))
+	if want := map[int]bool{1: true, 3: true, 4: true}; !reflect.DeepEqual(picked, want) {
+		t.Errorf("pickedHunks = %v, want %v", picked, want)
+	}
+}
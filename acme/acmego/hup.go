@@ -0,0 +1,37 @@
+//go:build !plan9
+// +build !plan9
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnHangup reloads the formatters in r every time the process
+// receives SIGHUP.
+func reloadOnHangup(r *registry) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			r.reload()
+		}
+	}()
+}
+
+// closeOnExit closes the formatters in r, as for registry.Close, and
+// exits when the process is interrupted or terminated.
+func closeOnExit(r *registry) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if err := r.Close(); err != nil {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}()
+}
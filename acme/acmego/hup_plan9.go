@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+)
+
+// reloadOnHangup does nothing on Plan 9, which has no SIGHUP.
+func reloadOnHangup(r *registry) {}
+
+// closeOnExit closes the formatters in r, as for registry.Close, and
+// exits when the process is interrupted.
+func closeOnExit(r *registry) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		if err := r.Close(); err != nil {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}()
+}
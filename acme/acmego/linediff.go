@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// edDiff returns the differences between old and new in the default
+// output format of diff(1), as GNU diff prints them: a minimal set of
+// changed lines, found with Myers's O(ND) algorithm, each run of them
+// an a, c or d command followed by the lines it removes and adds. A
+// last line with no newline is different from one with, and followed
+// by the "\ No newline at end of file" marker.
+func edDiff(old, new []byte) []byte {
+	a, b := splitLines(old), splitLines(new)
+	del, ins := make([]bool, len(a)), make([]bool, len(b))
+	d := &differ{}
+	d.a, d.b, d.ai, d.bi = internLines(a, b, del, ins)
+	d.del, d.ins = make([]bool, len(d.a)), make([]bool, len(d.b))
+	d.compare(0, len(d.a), 0, len(d.b))
+	for i, x := range d.del {
+		del[d.ai[i]] = x
+	}
+	for j, x := range d.ins {
+		ins[d.bi[j]] = x
+	}
+
+	var buf bytes.Buffer
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && !del[i] && !ins[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		i0, j0 := i, j
+		for i < len(a) && del[i] {
+			i++
+		}
+		for j < len(b) && ins[j] {
+			j++
+		}
+		switch {
+		case i == i0:
+			fmt.Fprintf(&buf, "%da%s\n", i0, edSpan(j0+1, j))
+		case j == j0:
+			fmt.Fprintf(&buf, "%sd%d\n", edSpan(i0+1, i), j0)
+		default:
+			fmt.Fprintf(&buf, "%sc%s\n", edSpan(i0+1, i), edSpan(j0+1, j))
+		}
+		writeEdLines(&buf, "< ", a[i0:i])
+		if i > i0 && j > j0 {
+			buf.WriteString("---\n")
+		}
+		writeEdLines(&buf, "> ", b[j0:j])
+	}
+	return buf.Bytes()
+}
+
+// splitLines returns the lines of text, each with its newline, if any.
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// internLines returns the lines of a and b as numbers, the same for
+// the same lines, which compare faster than the text, and the indexes
+// in a and b of each. Lines that are in only one of a and b are left
+// out, marked in del or ins, since they cannot but change; a rewritten
+// text then takes no time to compare.
+func internLines(a, b []string, del, ins []bool) (an, bn, ai, bi []int) {
+	type id struct {
+		n   int
+		inB bool
+	}
+	ids := make(map[string]*id)
+	for _, line := range a {
+		if ids[line] == nil {
+			ids[line] = &id{n: len(ids)}
+		}
+	}
+	for j, line := range b {
+		id := ids[line]
+		if id == nil {
+			ins[j] = true
+			continue
+		}
+		id.inB = true
+		bn = append(bn, id.n)
+		bi = append(bi, j)
+	}
+	for i, line := range a {
+		id := ids[line]
+		if !id.inB {
+			del[i] = true
+			continue
+		}
+		an = append(an, id.n)
+		ai = append(ai, i)
+	}
+	return an, bn, ai, bi
+}
+
+// edSpan returns the line range start through end as diff(1) writes
+// it, a single number if it is one line.
+func edSpan(start, end int) string {
+	if start == end {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, end)
+}
+
+// writeEdLines writes lines to buf after prefix, marking a last line
+// with no newline.
+func writeEdLines(buf *bytes.Buffer, prefix string, lines []string) {
+	for _, line := range lines {
+		buf.WriteString(prefix + line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n" + noEOL + "\n")
+		}
+	}
+}
+
+// A differ finds the lines to delete from a and insert from b to turn
+// a into b. Ai and bi are the indexes of those lines in the texts.
+type differ struct {
+	a, b     []int
+	ai, bi   []int
+	del, ins []bool
+	vbuf     []int
+}
+
+// compare marks the lines of a[a0:a1] to delete and those of b[b0:b1]
+// to insert, dividing the problem at the middle snake of a shortest
+// edit script until what is left is only deletions or insertions.
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0, b0 = a0+1, b0+1
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1, b1 = a1-1, b1-1
+	}
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.ins[j] = true
+		}
+		return
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.del[i] = true
+		}
+		return
+	}
+	xs, ys, xe, ye := d.middleSnake(a0, a1, b0, b1)
+	d.compare(a0, a0+xs, b0, b0+ys)
+	d.compare(a0+xe, a1, b0+ye, b1)
+}
+
+// middleSnake returns the start and end, relative to a0 and b0, of
+// the middle snake of a shortest edit script turning a[a0:a1] into
+// b[b0:b1]: the diagonal run of equal lines where the paths searched
+// from both ends with as few edits as each other first overlap. The
+// lines before and after it take fewer edits than the whole.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (xs, ys, xe, ye int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta&1 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	size := 2*max + 3
+	if cap(d.vbuf) < 2*size {
+		d.vbuf = make([]int, 2*size)
+	}
+	// vf and vb hold the furthest x reached on each diagonal k = x-y,
+	// going forward from the start and backward from the end; vb in
+	// the coordinates of the reversed texts.
+	vf, vb := d.vbuf[:size], d.vbuf[size:2*size]
+	vf[off+1], vb[off+1] = 0, 0
+	for D := 0; D <= max; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || k != D && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x, y = x+1, y+1
+			}
+			vf[off+k] = x
+			if kb := delta - k; odd && -(D-1) <= kb && kb <= D-1 && x+vb[off+kb] >= n {
+				return sx, sy, x, y
+			}
+		}
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || k != D && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x, y = x+1, y+1
+			}
+			vb[off+k] = x
+			if kf := delta - k; !odd && -D <= kf && kf <= D && x+vf[off+kf] >= n {
+				return n - x, m - y, n - sx, m - sy
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
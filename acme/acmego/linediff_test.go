@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// edDiffTests holds pairs of texts and the output of GNU diff for them,
+// besides those of diffTests.
+var edDiffTests = []struct {
+	name     string
+	old, new string
+	diff     string
+}{
+	{"same", "a\nb\n", "a\nb\n", ""},
+	{"empty", "", "", ""},
+	{"from empty", "", "a\nb\n", "0a1,2\n> a\n> b\n"},
+	{"to empty", "a\nb\n", "", "1,2d0\n< a\n< b\n"},
+	{"no newline added", "", "a", "0a1\n> a\n\\ No newline at end of file\n"},
+	{
+		"interleaved",
+		"a\nb\nc\nd\ne\nf\ng\n",
+		"a\nc\nd\nX\ne\ng\nh\n",
+		"2d1\n< b\n4a4\n> X\n6d5\n< f\n7a7\n> h\n",
+	},
+	{
+		"moved line",
+		"a\nb\nc\nd\n",
+		"b\nc\nd\na\n",
+		"1d0\n< a\n4a4\n> a\n",
+	},
+}
+
+func TestEdDiff(t *testing.T) {
+	for _, test := range diffTests {
+		if diff := edDiff([]byte(test.old), []byte(test.new)); string(diff) != test.diff {
+			t.Errorf("%s: edDiff = %q, want %q", test.name, diff, test.diff)
+		}
+	}
+	for _, test := range edDiffTests {
+		if diff := edDiff([]byte(test.old), []byte(test.new)); string(diff) != test.diff {
+			t.Errorf("%s: edDiff = %q, want %q", test.name, diff, test.diff)
+		}
+	}
+}
+
+// TestEdDiffRandom checks that the diffs of random texts turn the old
+// text into the new, and change as few lines as diff(1) does.
+func TestEdDiffRandom(t *testing.T) {
+	_, err := exec.LookPath("diff")
+	haveDiff := err == nil
+	rnd := rand.New(rand.NewSource(1))
+	text := func() []byte {
+		var b bytes.Buffer
+		for n := rnd.Intn(20); n > 0; n-- {
+			b.WriteString("abcd"[rnd.Intn(4):][:1] + "\n")
+		}
+		if rnd.Intn(4) == 0 {
+			b.WriteString("e")
+		}
+		return b.Bytes()
+	}
+	for i := 0; i < 500; i++ {
+		old, new := text(), text()
+		diff := edDiff(old, new)
+		edits, err := computeEdits(diff, old, new, false)
+		if err != nil {
+			t.Fatalf("edDiff(%q, %q) = %q: %v", old, new, diff, err)
+		}
+		body := []rune(string(old))
+		for _, e := range edits {
+			q0, q1, ok := resolveAddr(body, e.addr)
+			if !ok {
+				t.Fatalf("edDiff(%q, %q) = %q: cannot resolve address %q", old, new, diff, e.addr)
+			}
+			body = append(body[:q0], append([]rune(string(e.data)), body[q1:]...)...)
+		}
+		if string(body) != string(new) {
+			t.Fatalf("edDiff(%q, %q) = %q, which edits into %q", old, new, diff, string(body))
+		}
+		if haveDiff && i < 100 {
+			want := gnuDiff(t, old, new)
+			if changed(diff) != changed(want) {
+				t.Errorf("edDiff(%q, %q) = %q, changing more lines than %q", old, new, diff, want)
+			}
+		}
+	}
+}
+
+// gnuDiff returns the output of diff(1) for old and new.
+func gnuDiff(t *testing.T, old, new []byte) []byte {
+	a, err := tempFile("", "acmego", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(a)
+	b, err := tempFile("", "acmego", new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(b)
+	out, _ := exec.Command("diff", a, b).Output()
+	return out
+}
+
+// changed returns the number of lines a diff removes or adds.
+func changed(diff []byte) int {
+	n := 0
+	for _, line := range strings.Split(string(diff), "\n") {
+		if strings.HasPrefix(line, "< ") || strings.HasPrefix(line, "> ") {
+			n++
+		}
+	}
+	return n
+}
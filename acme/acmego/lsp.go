@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// LspFmt formats with a language server, args, such as gopls or
+// rust-analyzer, asking it for the edits of textDocument/formatting so
+// that files are formatted as the editor's other tools would. A server
+// is started for each project, the nearest directory with one of
+// markers, the first time a file in it is formatted, and kept running
+// for the next ones until Close. If the server cannot be started or
+// fails to format the file, fallback is used instead, if not nil; the
+// command-line formatters tell what is wrong with a file better.
+type LspFmt struct {
+	args     []string
+	lang     string // the languageId of the files
+	markers  []string
+	timeout  time.Duration
+	fallback Formatter
+}
+
+func (ls *LspFmt) format(file string) ([]byte, error) {
+	new, err := ls.lspFormat(file)
+	if err == nil {
+		return new, nil
+	}
+	if ls.fallback != nil {
+		debugf("%s %s: %v; using the command-line formatter", ls.args[0], file, err)
+		return ls.fallback.format(file)
+	}
+	fmtError(file, ls.args[0], err, nil)
+	return nil, err
+}
+
+func (ls *LspFmt) lspFormat(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	c, err := lspServer(ls.args, resolveWorkdir(file, ls.markers), ls.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc := map[string]interface{}{"uri": fileURI(file)}
+	err = c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        doc["uri"],
+			"languageId": ls.lang,
+			"version":    1,
+			"text":       string(src),
+		},
+	})
+	if err != nil {
+		c.fail()
+		return nil, err
+	}
+	defer c.notify("textDocument/didClose", map[string]interface{}{"textDocument": doc})
+	var edits []lspTextEdit
+	err = c.call("textDocument/formatting", map[string]interface{}{
+		"textDocument": doc,
+		"options":      map[string]interface{}{"tabSize": 8, "insertSpaces": false},
+	}, &edits, ls.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return applyLspEdits(src, edits)
+}
+
+// Close shuts down the servers started for args.
+func (ls *LspFmt) Close() error {
+	lspServers.Lock()
+	var servers []*lspEntry
+	for key, e := range lspServers.m {
+		if strings.HasPrefix(key, strings.Join(ls.args, " ")+"\x00") {
+			servers = append(servers, e)
+			delete(lspServers.m, key)
+		}
+	}
+	lspServers.Unlock()
+	for _, e := range servers {
+		e.mu.Lock()
+		if e.c != nil {
+			e.c.shutdown(ls.timeout)
+		}
+		e.c, e.closed = nil, true
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// lspServers holds the language servers by command and project
+// directory, so that they are shared with the LspFmts made when the
+// formatters are reloaded.
+var lspServers = struct {
+	sync.Mutex
+	m map[string]*lspEntry
+}{m: make(map[string]*lspEntry)}
+
+// An lspEntry is the server for a command and project. Its lock is held
+// while the server is started, so that formats for the project wait for
+// it without holding up those for the others.
+type lspEntry struct {
+	mu     sync.Mutex
+	c      *lspConn // nil until started
+	closed bool     // removed from lspServers by Close
+}
+
+// lspServer returns the server running args for the project in root,
+// starting it if there is none.
+func lspServer(args []string, root string, timeout time.Duration) (*lspConn, error) {
+	key := strings.Join(args, " ") + "\x00" + root
+	for {
+		lspServers.Lock()
+		e := lspServers.m[key]
+		if e == nil {
+			e = &lspEntry{}
+			lspServers.m[key] = e
+		}
+		lspServers.Unlock()
+		e.mu.Lock()
+		if e.closed {
+			// Close took it while we waited; a server started now
+			// would be left running.
+			e.mu.Unlock()
+			continue
+		}
+		if e.c != nil && !e.c.dead() {
+			e.mu.Unlock()
+			return e.c, nil
+		}
+		if e.c != nil {
+			go e.c.wait()
+		}
+		c, err := startLspServer(args, root, timeout)
+		e.c = c
+		e.mu.Unlock()
+		return c, err
+	}
+}
+
+// startLspServer starts args for the project in root and initializes
+// it.
+func startLspServer(args []string, root string, timeout time.Duration) (*lspConn, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = root
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := newLspConn(r, w, func() { cmd.Process.Kill() })
+	c.wait = cmd.Wait
+	uri := fileURI(root)
+	err = c.call("initialize", map[string]interface{}{
+		"processId":        os.Getpid(),
+		"rootUri":          uri,
+		"capabilities":     map[string]interface{}{},
+		"workspaceFolders": []interface{}{map[string]interface{}{"uri": uri, "name": filepath.Base(root)}},
+	}, nil, timeout)
+	if err == nil {
+		err = c.notify("initialized", map[string]interface{}{})
+	}
+	if err != nil {
+		c.fail()
+		c.wait()
+		return nil, fmt.Errorf("starting %s: %v", args[0], err)
+	}
+	debugf("started %s for %s", args[0], root)
+	return c, nil
+}
+
+// fileURI returns the file URI of the absolute path file.
+func fileURI(file string) string {
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// An lspConn is a JSON-RPC connection to a language server. Calls are
+// made one at a time, holding mu.
+type lspConn struct {
+	mu sync.Mutex
+	r  *bufio.Reader
+	w  io.WriteCloser
+	id int
+
+	kill func()       // stops the server
+	wait func() error // waits for it to exit, if it is a process
+
+	failMu sync.Mutex
+	failed bool
+}
+
+func newLspConn(r io.Reader, w io.WriteCloser, kill func()) *lspConn {
+	return &lspConn{r: bufio.NewReader(r), w: w, kill: kill, wait: func() error { return nil }}
+}
+
+// fail stops the server after an error; a new one is started for the
+// next format.
+func (c *lspConn) fail() {
+	c.failMu.Lock()
+	c.failed = true
+	c.failMu.Unlock()
+	c.kill()
+}
+
+func (c *lspConn) dead() bool {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+	return c.failed
+}
+
+// An lspMessage is a request, response or notification.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call sends the request method and decodes the result of its response
+// into result, unless result is nil. It answers the requests the server
+// makes in the meantime, and skips its notifications. If there is no
+// response within timeout, unless it is 0, the server is stopped.
+func (c *lspConn) call(method string, params, result interface{}, timeout time.Duration) error {
+	c.id++
+	id := strconv.Itoa(c.id)
+	msg := map[string]interface{}{"jsonrpc": "2.0", "id": c.id, "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	if err := c.send(msg); err != nil {
+		c.fail()
+		return err
+	}
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, c.fail)
+		defer timer.Stop()
+	}
+	for {
+		m, err := c.read()
+		if err != nil {
+			if timer != nil && !timer.Stop() {
+				err = fmt.Errorf("%s timed out after %v", method, timeout)
+			}
+			c.fail()
+			return err
+		}
+		switch {
+		case m.Method != "" && m.ID != nil:
+			if err := c.send(map[string]interface{}{"jsonrpc": "2.0", "id": m.ID, "result": lspReply(m)}); err != nil {
+				c.fail()
+				return err
+			}
+		case m.Method != "":
+			// A notification, such as a log message.
+		case string(m.ID) == id:
+			if m.Error != nil {
+				return fmt.Errorf("%s: %s", method, m.Error.Message)
+			}
+			if result == nil || len(m.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(m.Result, result)
+		}
+	}
+}
+
+// lspReply returns the result to answer request m from the server with:
+// no settings for workspace/configuration, and null for the others,
+// such as the registration of capabilities.
+func lspReply(m *lspMessage) interface{} {
+	if m.Method != "workspace/configuration" {
+		return nil
+	}
+	var params struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	json.Unmarshal(m.Params, &params)
+	return make([]interface{}, len(params.Items))
+}
+
+// notify sends the notification method.
+func (c *lspConn) notify(method string, params interface{}) error {
+	return c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (c *lspConn) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func (c *lspConn) read() (*lspMessage, error) {
+	n := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if n, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("bad header %q", line)
+			}
+		}
+	}
+	if n < 0 {
+		return nil, errors.New("message with no Content-Length")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	m := new(lspMessage)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// shutdown asks the server to exit, and stops it if it does not
+// within timeout, unless it is 0.
+func (c *lspConn) shutdown(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dead() {
+		return
+	}
+	if err := c.call("shutdown", nil, nil, timeout); err == nil {
+		c.notify("exit", nil)
+	}
+	c.w.Close()
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, c.kill)
+		defer timer.Stop()
+	}
+	if err := c.wait(); err != nil && !c.dead() {
+		log.Print(err)
+	}
+}
+
+// lspTextEdit is a TextEdit of the protocol.
+type lspTextEdit struct {
+	Range struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	} `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// lspPosition is a position in a document: the line, counting from 0,
+// and the character in it, counting UTF-16 code units.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// offset returns the byte offset of p in text, whose lines start at
+// the offsets starts, as lineStarts returns them.
+func (p lspPosition) offset(text []byte, starts []int) (int, error) {
+	if p.Line >= len(starts) {
+		return 0, fmt.Errorf("line %d is past the end", p.Line+1)
+	}
+	i := starts[p.Line]
+	for units := 0; units < p.Character && i < len(text) && text[i] != '\n'; {
+		r, size := utf8.DecodeRune(text[i:])
+		if r >= 0x10000 {
+			units++
+		}
+		units++
+		i += size
+	}
+	return i, nil
+}
+
+// lineStarts returns the offsets of the starts of the lines of text.
+// The text after a final newline counts as a line, empty.
+func lineStarts(text []byte) []int {
+	starts := []int{0}
+	for i := 0; ; {
+		j := bytes.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return starts
+		}
+		i += j + 1
+		starts = append(starts, i)
+	}
+}
+
+// applyLspEdits returns text with the edits, which are all positioned
+// in text and do not overlap, made to it.
+func applyLspEdits(text []byte, edits []lspTextEdit) ([]byte, error) {
+	type span struct {
+		i, start, end int
+		text          string
+	}
+	starts := lineStarts(text)
+	spans := make([]span, len(edits))
+	for i, e := range edits {
+		start, err := e.Range.Start.offset(text, starts)
+		if err != nil {
+			return nil, err
+		}
+		end, err := e.Range.End.offset(text, starts)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("edit %d ends before it starts", i)
+		}
+		spans[i] = span{i, start, end, e.NewText}
+	}
+	// Make the edits from the end, and those at the same place in
+	// reverse order, so that each lands before those made already.
+	sort.Slice(spans, func(a, b int) bool {
+		if spans[a].start != spans[b].start {
+			return spans[a].start > spans[b].start
+		}
+		return spans[a].i > spans[b].i
+	})
+	out := append([]byte(nil), text...)
+	for _, s := range spans {
+		out = append(out[:s.start], append([]byte(s.text), out[s.end:]...)...)
+	}
+	return out, nil
+}
+
+// lspLangs are the languageIds of the extensions that differ from them.
+var lspLangs = map[string]string{
+	"rs": "rust", "py": "python", "rb": "ruby", "js": "javascript",
+	"ts": "typescript", "kt": "kotlin", "ml": "ocaml", "tf": "terraform",
+}
+
+// lspMarkers are the files that mark the projects of the extensions.
+var lspMarkers = map[string][]string{
+	"go": goMarkers, "rs": rustMarkers, "py": pyMarkers, "elm": elmMarkers,
+	"dart": dartMarkers, "rb": rubyMarkers, "kt": kotlinMarkers,
+}
+
+// lspFmts puts LspFmts for the servers in the comma-separated list s
+// of ext=command in fmts, falling back to the formatter there was for
+// each extension.
+func lspFmts(fmts map[string]Formatter, s string) error {
+	cmds, err := extCommands(s)
+	if err != nil {
+		return err
+	}
+	for ext, args := range cmds {
+		lang := lspLangs[ext]
+		if lang == "" {
+			lang = ext
+		}
+		fmts[ext] = &LspFmt{args: args, lang: lang, markers: lspMarkers[ext], timeout: *cmdTimeout, fallback: fmts[ext]}
+	}
+	return nil
+}
+
+// extCommands parses the comma-separated list s of ext=command into a
+// map from extension to the arguments of the command.
+func extCommands(s string) (map[string][]string, error) {
+	cmds := make(map[string][]string)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		i := strings.Index(e, "=")
+		args := strings.Fields(e[i+1:])
+		if i < 0 || len(args) == 0 {
+			return nil, fmt.Errorf("bad command %q: want ext=command", e)
+		}
+		cmds[strings.TrimPrefix(strings.TrimSpace(e[:i]), ".")] = args
+	}
+	return cmds, nil
+}
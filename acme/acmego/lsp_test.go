@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func lspEdit(l0, c0, l1, c1 int, text string) lspTextEdit {
+	var e lspTextEdit
+	e.Range.Start = lspPosition{l0, c0}
+	e.Range.End = lspPosition{l1, c1}
+	e.NewText = text
+	return e
+}
+
+func TestApplyLspEdits(t *testing.T) {
+	src := []byte("package p\nvar  x = \"\U0001f600\u00e9\"  \nfunc f(){}\n")
+	edits := []lspTextEdit{
+		lspEdit(2, 8, 2, 8, " "),
+		lspEdit(1, 3, 1, 5, " "),
+		lspEdit(1, 14, 1, 16, ""), // after a rune of two UTF-16 units and one of one
+		lspEdit(2, 9, 2, 9, "\n"),
+		lspEdit(2, 9, 2, 9, "}"),
+		lspEdit(2, 9, 2, 10, ""),
+	}
+	out, err := applyLspEdits(src, edits)
+	if want := "package p\nvar x = \"\U0001f600\u00e9\"\nfunc f() {\n}\n"; err != nil || string(out) != want {
+		t.Errorf("applyLspEdits = %q, %v, want %q", out, err, want)
+	}
+	if _, err := applyLspEdits(src, []lspTextEdit{lspEdit(9, 0, 9, 0, "x")}); err == nil {
+		t.Errorf("applyLspEdits past the end: no error")
+	}
+}
+
+// fakeServer answers the requests on c like a language server that
+// asks for its configuration before formatting, as gopls does.
+func fakeServer(t *testing.T, c *lspConn, edits []lspTextEdit) {
+	for {
+		m, err := c.read()
+		if err != nil {
+			return
+		}
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": m.ID}
+		switch m.Method {
+		case "textDocument/formatting":
+			c.notify("window/logMessage", map[string]interface{}{"type": 3, "message": "formatting"})
+			c.send(map[string]interface{}{"jsonrpc": "2.0", "id": 99, "method": "workspace/configuration",
+				"params": map[string]interface{}{"items": []interface{}{map[string]interface{}{}}}})
+			m, err := c.read()
+			if err != nil || string(m.ID) != "99" || string(m.Result) != "[null]" {
+				t.Errorf("configuration reply = %s, %v", m.Result, err)
+			}
+			reply["result"] = edits
+		case "shutdown":
+			reply["result"] = nil
+		default:
+			continue
+		}
+		c.send(reply)
+	}
+}
+
+func TestLspConn(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	kill := func() { cr.Close(); sr.Close() }
+	client, server := newLspConn(cr, cw, kill), newLspConn(sr, sw, kill)
+	want := []lspTextEdit{lspEdit(0, 1, 0, 2, "x")}
+	go fakeServer(t, server, want)
+	var edits []lspTextEdit
+	if err := client.call("textDocument/formatting", map[string]interface{}{}, &edits, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(edits); string(got) != mustMarshal(want) {
+		t.Errorf("edits = %s, want %s", got, mustMarshal(want))
+	}
+	if err := client.call("textDocument/hover", nil, nil, 10*time.Millisecond); err == nil || !client.dead() {
+		t.Errorf("call with no response = %v, dead %v, want a timeout", err, client.dead())
+	}
+}
+
+func mustMarshal(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
@@ -6,212 +6,677 @@
 // Each time a .go file is written, acmego checks whether the
 // import block needs adjustment. If so, it makes the changes
 // in the window body but does not write the file.
+//
+// Run as "acmego fmt -ext ext", it formats standard input instead,
+// as it would a file with that extension, and prints the result.
 package main
 
 import (
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"9fans.net/go/acme"
 )
 
+var (
+	hookInterval  = flag.Duration("hookinterval", 5*time.Second, "run bl2plus at most once per `interval` for each file")
+	afterCmd      = flag.String("after", "", "run `command` once formatting has been idle for -afterdelay, such as to regenerate tags or lint the project")
+	afterDelay    = flag.Duration("afterdelay", 5*time.Second, "run the -after command when no file has been formatted for `delay`")
+	nixCmd        = flag.String("nix", "nixpkgs-fmt", "format .nix files with `command`, nixpkgs-fmt or alejandra")
+	retryModified = flag.Bool("retry", false, "if a window changes while it is formatted, format its new contents once more instead of giving up")
+	goFallback    = flag.String("gofallback", "", "format .go files with `command` when goimports fails")
+	buildTags     = flag.String("buildtags", "", "comma-separated build `tags` for goimports; by default those the file's build constraints require")
+	goLocal       = flag.String("local", "", "pass -local `prefix` to goimports; by default the module path in the nearest go.mod is used")
+	keepImports   = flag.Bool("keepimports", false, "format .go files with gofmt instead of goimports, which keeps unused imports but no longer adds missing ones")
+	goFumpt       = flag.Bool("gofumpt", false, "format .go files with gofumpt instead of goimports, for its stricter style; like gofmt, it leaves imports alone")
+	goTestCmd     = flag.String("gotest", "", "format _test.go files with `command` too, after the Go formatter: it is given the file name and prints the file formatted")
+	goRewrite     = flag.String("gorewrite", "", "semicolon-separated gofmt -r `rules`, such as \"interface{} -> any\", to rewrite .go files with after the Go formatter, in order and until they no longer change the code")
+	goTags        = flag.Bool("gotags", false, "align the key:\"value\" pairs of Go struct tags into columns")
+	styluaArgs    = flag.String("stylua", "", "pass `args` to stylua, such as \"--indent-type Spaces --indent-width 2\"")
+	triggerOps    = flag.String("ops", "put", "comma-separated acme log `ops` that trigger formatting")
+	ignoreSpace   = flag.Bool("ignorews", false, "do not apply changes that only touch trailing white space")
+	keepSpaceExts = flag.String("wsexts", "anyext", "comma-separated `extensions` whose formatters change trailing white space on purpose and are exempt from -ignorews")
+	emptyExts     = flag.String("emptyok", "", "comma-separated `extensions` for which a formatter may legitimately turn a non-empty file into an empty one")
+	terraformCmd  = flag.String("terraform", "terraform", "format .tf and .hcl files with `command`, terraform or tofu")
+	changedOnly   = flag.Bool("changed", false, "in git repositories, only apply formatting to lines that differ from HEAD")
+	quiet         = flag.Bool("q", false, "quiet: only log warnings and errors")
+	bgQuiet       = flag.Bool("bgquiet", false, "be quiet, as with -q, about files written in the background: those whose windows do not have the focus")
+	verbose       = flag.Bool("v", false, "verbose: also log debugging messages, such as the versions of formatters")
+	fmtConfigFile = flag.String("config", configFile("formatters.json"), "read formatters from the JSON `file`, mapping extensions, or anyext, to commands such as {\"cmd\": \"black\", \"args\": [\"-q\"]} or \"black -q\", given the file name and printing it formatted, or to lists of them to run in turn; they replace the built-in ones, and are read again on SIGHUP")
+	pauseFile     = flag.String("pausefile", configFile("disabled"), "do not format anything while `file` exists")
+	rubyCmd       = flag.String("ruby", "rubocop", "format .rb files with `command`, rubocop or standardrb")
+	kotlinCmd     = flag.String("kotlin", "ktlint", "format .kt and .kts files with `command`, ktlint or ktfmt")
+	phpCmd        = flag.String("php", "php-cs-fixer", "format .php files with `command`, php-cs-fixer or phpcbf")
+	dockerCmd     = flag.String("docker", "dockerfmt", "format Dockerfiles with `command`; if it is not installed they are tidied in-process")
+	hadolintCmd   = flag.String("hadolint", "hadolint", "check Dockerfiles with `command`, showing its warnings in +Errors; empty for none")
+	watchRoots    = flag.String("roots", "", "only format files under these `directories`, a list separated like $PATH; empty means everywhere")
+	errDot        = flag.Bool("errdot", false, "when formatting fails, move the dot to the first line with an error")
+	allowlist     = flag.Bool("only", false, "leave files alone unless there is a formatter for their extension: no anyext formatter, no bl2plus")
+	mdWidth       = flag.Int("mdwidth", 0, "reflow Markdown paragraphs to `columns`, when they are tidied in-process; 0 leaves them alone")
+	prettierCmd   = flag.String("prettier", "prettier", "format .js, .ts, .jsx, .tsx, .css and .md files with `command`; if it is not installed Markdown is tidied in-process")
+	xmlIndent     = flag.Int("xmlindent", 2, "indent .xml files by `n` blanks a level, or a tab if 0")
+	csvPlain      = flag.Bool("csvplain", false, "align the columns of .csv and .tsv files, padding fields with blanks before their separators, which no longer keeps the records as they are")
+	maxSize       = flag.Int64("maxsize", 1<<20, "skip files larger than `bytes`; 0 means no limit")
+	maxSizeExts   = flag.String("maxsizes", "", "comma-separated ext=bytes `limits` overriding -maxsize for files with those extensions, such as \"csv=0,json=4194304\"")
+	genExts       = flag.String("genexts", "go", "comma-separated `extensions` of files to leave alone when they are marked as generated")
+	genMarker     = flag.String("genmarker", `^(//|#|--|;|/\*|\*) Code generated .* DO NOT EDIT\.$`, "`regexp` matching the comment line that marks a file as generated")
+	manualExts    = flag.String("manual", "", "comma-separated `extensions`, or names such as Makefile, of files to format only when Fmt is executed, not when they are written; their windows get a Fmt command as with -fmttag")
+	fmtTag        = flag.Bool("fmttag", false, "add a Fmt command to the tags of windows with a formatter; executing it formats the window body, saved or not, and executing FmtDot formats the Go code at the dot")
+	niceness      = flag.Int("nice", 0, "run formatters at `niceness`, from 1 to 19 for ever lower priority; 0 leaves it as acmego's")
+	cmdTimeout    = flag.Duration("timeout", 10*time.Second, "kill a formatter command, and the processes it started, when it runs for longer than `duration`; 0 for no limit")
+	stallLimit    = flag.Duration("stall", time.Minute, "warn when formatting one file takes longer than `duration`, as when a formatter hangs; 0 for no warning")
+	debounce      = flag.Duration("debounce", 150*time.Millisecond, "format the writes to a window that come within `delay` of the first once, for the last of them; 0 formats every write")
+	workers       = flag.Int("workers", runtime.GOMAXPROCS(0), "format at most `n` windows at once; the writes of one window are formatted one at a time, in order")
+	mergeGap      = flag.Int("mergegap", 0, "merge the changes that are at most `n` unchanged lines apart into one edit; 0 merges none")
+	maxHunks      = flag.Int("maxhunks", 500, "replace the whole body at once when formatting makes more than `n` edits; 0 for no limit")
+	anyextFmts    = flag.String("anyext", "eol", "comma-separated `formatters` to run in turn on files with no formatter of their own: the built-in trim (trailing white space) and eol (exactly one final newline), or the external aeol")
+	embedExts     = flag.String("embed", "", "comma-separated `extensions` of files in which to format the code of other languages tagged with a language=lang comment too: Go raw strings, or the here-documents of other files; such as \"go,sh\"")
+	embedCmds     = flag.String("embedcmd", "", "comma-separated lang=command `formatters` for the embedded code of languages with no formatter of their own, such as \"sql=pg_format\"; the command is given the file name and prints the code formatted")
+	lspCmds       = flag.String("lsp", "", "comma-separated ext=command `servers` to format files with those extensions with, over the language server protocol, such as \"go=gopls,rs=rust-analyzer\"; the usual formatter is used when the server fails")
+	jobLimits     = flag.String("jobs", "", "comma-separated ext=n `limits` on how many formats of files with those extensions may run at once, such as \"rb=1,kt=2\"")
+	backupDir     = flag.String("backupdir", "", "before editing a window, save a copy of its body in `dir`")
+	backupKeep    = flag.Int("backups", 5, "keep the newest `n` backups of each file in -backupdir")
+	backupAge     = flag.Duration("backupage", 7*24*time.Hour, "remove backups older than `age` from -backupdir, checking every hour")
+	pickHunks     = flag.Bool("pick", false, "show the changes to each file as hunks in a window named file+hunks, to apply only those left there when Apply is executed")
+	dryRun        = flag.Bool("n", false, "only log the changes formatting would make to each window, as diff(1) commands, leaving the windows and the files alone")
+	preview       = flag.Bool("preview", false, "show the formatted result in a new window named file+formatted instead of editing the window of the file")
+	eventFile     = flag.String("events", "", "append a JSON object describing each formatting to `file`, or write it to standard output if file is -")
+	checkIdem     = flag.Bool("idem", false, "format the output of each formatter again and warn if that changes it")
+)
+
 func main() {
-	fmts := newFmts()
+	flag.Parse()
+	if flag.Arg(0) == "fmt" {
+		fmtMain(flag.Args()[1:])
+		return
+	}
+	fmts := newRegistry(newFmts())
+	reloadOnHangup(fmts)
+	closeOnExit(fmts)
+	bl2plus := newHook("bl2plus", *hookInterval)
+	after := newBatchHook(*afterCmd, *afterDelay)
+	ops := splitSet(*triggerOps)
+	spaceExts := splitSet(*keepSpaceExts)
+	emptyOK := splitSet(*emptyExts)
+	manual := splitSet(*manualExts)
+	genOnly := splitSet(*genExts)
+	genRE, err := regexp.Compile(*genMarker)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pause := &pauser{path: *pauseFile}
+	saved = backups{dir: *backupDir, keep: *backupKeep}
+	saved.pruneEvery(time.Hour, *backupAge)
+	if *eventFile != "" {
+		if fmtEvents, err = openEventLog(*eventFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	roots := filepath.SplitList(*watchRoots)
+	sizes, err := extLimits(*maxSizeExts, "bytes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := extLimits(*jobLimits, "n"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := parseRewriteRules(*goRewrite); err != nil {
+		log.Fatalf("-gorewrite: %v", err)
+	}
+	if _, err := extCommands(*embedCmds); err != nil {
+		log.Fatalf("-embedcmd: %v", err)
+	}
+	if _, err := extCommands(*lspCmds); err != nil {
+		log.Fatal(err)
+	}
+	// optionsFor returns the options for formatting a file with
+	// extension ext using the formatter registered for fext.
+	optionsFor := func(ext, fext string) options {
+		opt := options{
+			ignoreSpace: *ignoreSpace && !spaceExts[fext],
+			maxSize:     *maxSize,
+			allowEmpty:  emptyOK[fext],
+		}
+		if limit, ok := sizes[ext]; ok {
+			opt.maxSize = limit
+		}
+		if genOnly[ext] {
+			opt.generated = genRE
+		}
+		return opt
+	}
 	l, err := acme.Log()
 	if err != nil {
 		log.Fatal(err)
 	}
+	logged := make(chan acme.LogEvent)
+	dog := newWatchdog(*stallLimit)
+	pool := newWorkerPool(*workers, dog)
+	go func() {
+		err := readLog(l, logged)
+		// Acme is gone.
+		fmts.Close()
+		log.Fatal(err)
+	}()
+	events := debounceEvents(logged, *debounce, func(event acme.LogEvent) bool {
+		return ops[event.Op]
+	})
+	tags := newTagger()
+	focused := 0 // the window last focused, if any
 
 	for {
-		event, err := l.Read()
-		if err != nil {
-			log.Fatal(err)
-		}
-		modified := false
-		anyextFmtUsed := false
-		if event.Name != "" && event.Op == "put" {
-			if fmter, ok := fmts[fileExt(event.Name)]; ok {
-				modified = reformat(event.ID, event.Name, fmter)
-			} else {
+		select {
+		case event := <-events:
+			if event.Op == "focus" {
+				focused = event.ID
+			}
+			if event.Name == "" || !underRoots(event.Name, roots) {
+				continue
+			}
+			if tagOps[event.Op] {
+				if _, ext, ok := fmts.lookupFile(event.Name); ok && (*fmtTag || manual[ext]) {
+					tags.add(event.ID)
+				}
+			}
+			if !ops[event.Op] || pause.check() {
+				continue
+			}
+			anyextFmtUsed := false
+			table, release := fmts.hold()
+			fmter, ext, ok := table.lookupFile(event.Name)
+			if !ok {
+				if *allowlist {
+					release()
+					continue
+				}
+				ext = "anyext"
+				fmter, ok = table.lookup(ext)
 				anyextFmtUsed = true
-				modified = reformat(event.ID, event.Name, fmts["anyext"])
 			}
-			if !modified || anyextFmtUsed {
-				output, _ := exec.Command("bl2plus", event.Name).CombinedOutput()
-				fmt.Fprintf(os.Stderr, "%s", output)
+			if manual[ext] {
+				// Formatted only with Fmt.
+				ok = false
+			}
+			if !ok {
+				release()
+				if !*dryRun {
+					bl2plus.run(event.Name)
+				}
+				continue
 			}
+			opt := optionsFor(fileExt(event.Name), ext)
+			opt.quiet = *bgQuiet && !interactive(event, focused)
+			pool.run(event.ID, func() {
+				defer release()
+				dog.begin(event.Name)
+				modified := reformat(event.ID, event.Name, fmter, opt)
+				dog.end(event.Name)
+				after.formatted(event.Name)
+				if (!modified || anyextFmtUsed) && !*dryRun {
+					bl2plus.run(event.Name)
+				}
+			})
+
+		case req := <-tags.reqs:
+			if !underRoots(req.name, roots) || pause.check() {
+				continue
+			}
+			if req.dot {
+				if fileExt(req.name) != "go" {
+					errorf(req.name, "acmego: FmtDot formats Go code only")
+					continue
+				}
+				pool.run(req.id, func() {
+					dog.begin(req.name)
+					formatDot(req.id, req.name)
+					dog.end(req.name)
+				})
+				continue
+			}
+			table, release := fmts.hold()
+			fmter, ext, ok := table.lookupFile(req.name)
+			if !ok {
+				release()
+				errorf(req.name, "acmego: no formatter for %s", req.name)
+				continue
+			}
+			opt := optionsFor(fileExt(req.name), ext)
+			opt.body = true
+			pool.run(req.id, func() {
+				defer release()
+				dog.begin(req.name)
+				reformat(req.id, req.name, fmter, opt)
+				dog.end(req.name)
+				after.formatted(req.name)
+			})
 		}
 	}
 }
 
-func fileExt(filePath string) string {
-	if n := strings.LastIndex(filePath, "."); n != -1 {
-		return filePath[n+1:]
+// infof logs a routine message, such as a report of successful
+// formatting. Unlike warnings and errors, these are silenced by -q.
+func infof(format string, args ...interface{}) {
+	if !*quiet {
+		log.Printf(format, args...)
 	}
-	return ""
 }
 
-func reformat(id int, name string, fmter Formatter) bool {
-	win, err := acme.Open(id, nil)
-	if err != nil {
-		log.Print(err)
-		return false
+// infof logs a routine message about the file being formatted with
+// opt, as infof does, unless opt.quiet is set.
+func (opt options) infof(format string, args ...interface{}) {
+	if !opt.quiet {
+		infof(format, args...)
 	}
-	w := Window{win, false}
-	defer w.CloseFiles()
+}
 
-	old, err := ioutil.ReadFile(name)
-	if err != nil {
-		//log.Print(err)
-		return false
+// interactive guesses whether the user made event, given the window
+// last focused. Acme's log does not tell, so the guess is that a window
+// written while it has the focus is being written by the user, with the
+// Put in its tag, and that other writes, such as those of Putall or of
+// programs writing to the window's ctl file, are made in the
+// background. Until there has been a focus event, as with versions of
+// acme that log none, every event counts as interactive.
+func interactive(event acme.LogEvent, focused int) bool {
+	return focused == 0 || event.ID == focused
+}
+
+// debugf logs a debugging message, shown only with -v.
+func debugf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
 	}
-	new, err := fmter.format(name)
-	if err != nil {
-		return false
+}
+
+// underRoots reports whether file is inside one of the directories
+// in roots. Any file is when roots is empty.
+func underRoots(file string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
 	}
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if file == root || strings.HasPrefix(file, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
 
-	if bytes.Equal(old, new) {
-		return false
+// splitSet returns the set of the non-empty elements of the
+// comma-separated list s.
+func splitSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			set[e] = true
+		}
 	}
+	return set
+}
 
-	f, err := ioutil.TempFile("", "acmego")
-	if err != nil {
-		log.Print(err)
-		return false
+// extLimits parses the comma-separated list s of ext=n limits, n
+// counting units, into a map from extension to limit.
+func extLimits(s, unit string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		i := strings.Index(e, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("bad limit %q: want ext=%s", e, unit)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(e[i+1:]), 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad limit %q: want ext=%s", e, unit)
+		}
+		limits[strings.TrimPrefix(strings.TrimSpace(e[:i]), ".")] = n
 	}
-	if _, err := f.Write(new); err != nil {
-		log.Print(err)
-		return false
+	return limits, nil
+}
+
+func fileExt(filePath string) string {
+	base := filepath.Base(filePath)
+	if n := strings.LastIndex(base, "."); n != -1 {
+		return base[n+1:]
 	}
-	tmp := f.Name()
-	f.Close()
-	defer os.Remove(tmp)
+	return ""
+}
+
+// options control how reformat treats a file.
+type options struct {
+	ignoreSpace bool  // leave out changes that only touch trailing white space
+	maxSize     int64 // skip files larger than this many bytes, unless 0
+	allowEmpty  bool  // accept empty output for a file that was not empty
+	quiet       bool  // log no routine messages, as for -q
+
+	// body makes reformat format the contents of the window, saved
+	// or not, rather than the file.
+	body bool
+
+	// generated, if not nil, matches the line marking files that are
+	// generated and should be left to their generator.
+	generated *regexp.Regexp
+}
 
-	diff, _ := exec.Command("/usr/bin/diff", name, tmp).CombinedOutput()
+// fmtEvents records each formatting done by reformat for -events; by
+// default it records nothing.
+var fmtEvents *eventLog
 
-	latest, err := w.ReadAll("body")
+// saved holds the backups made before reformat edits a window; by
+// default it makes none.
+var saved backups
+
+// reformat formats the file name shown in window id with fmter and
+// applies the changes to the window body.
+func reformat(id int, name string, fmter Formatter, opt options) bool {
+	// Check the file before touching the window, so that nothing
+	// is left half done in acme when it cannot be formatted. The
+	// body is formatted whatever the file on disk is, if any.
+	var old []byte
+	if !opt.body {
+		fi, err := os.Stat(name)
+		if err != nil {
+			log.Printf("skipping %s: %v", name, err)
+			return false
+		}
+		if !fi.Mode().IsRegular() {
+			log.Printf("skipping %s: not a regular file", name)
+			return false
+		}
+		if fi.Mode().Perm()&0222 == 0 {
+			opt.infof("skipping %s: file is read-only", name)
+			return false
+		}
+		if opt.maxSize > 0 && fi.Size() > opt.maxSize {
+			log.Printf("skipping %s: %d bytes is over the limit of %d", name, fi.Size(), opt.maxSize)
+			return false
+		}
+		if old, err = ioutil.ReadFile(name); err != nil {
+			log.Printf("skipping %s: %v", name, err)
+			return false
+		}
+		if opt.generated != nil && isGenerated(old, opt.generated) {
+			opt.infof("skipping %s: generated file", name)
+			return false
+		}
+	}
+
+	win, err := openWin(id)
 	if err != nil {
 		log.Print(err)
 		return false
 	}
-	if !bytes.Equal(old, latest) {
-		log.Printf("skipped update to %s: window modified since Put\n", name, len(old), len(latest))
-		return false
+	w := Window{win, false}
+	defer w.CloseFiles()
+	src := name
+	if opt.body {
+		body, err := w.ReadAll("body")
+		if err != nil {
+			log.Print(err)
+			return false
+		}
+		if opt.maxSize > 0 && int64(len(body)) > opt.maxSize {
+			log.Printf("skipping %s: %d bytes is over the limit of %d", name, len(body), opt.maxSize)
+			return false
+		}
+		if opt.generated != nil && isGenerated(body, opt.generated) {
+			opt.infof("skipping %s: generated file", name)
+			return false
+		}
+		tmp, err := tempFile(filepath.Dir(name), ".acmego-*"+filepath.Ext(name), body)
+		if err != nil {
+			log.Print(err)
+			return false
+		}
+		defer os.Remove(tmp)
+		src, old = tmp, body
 	}
+	// fail is the error, if any, that stops the formatting, for
+	// the -events output.
+	var fail error
+	hunks := 0
+	ev := fmtEvents.start(name, fmter)
+	defer func() { fmtEvents.done(ev, hunks, fail) }()
+	var new []byte
+	var edits []edit
+	for retried := false; ; retried = true {
+		new, err = fmter.format(src)
+		if err != nil {
+			fail = err
+			if *errDot && !*dryRun {
+				showError(&w, name, new)
+			}
+			return false
+		}
 
-	w.Write("ctl", []byte("mark"))
-	w.Write("ctl", []byte("nomark"))
-	diffLines := strings.Split(string(diff), "\n")
-	for i := len(diffLines) - 1; i >= 0; i-- {
-		line := diffLines[i]
-		if line == "" {
-			continue
+		if bytes.Equal(old, new) {
+			return false
 		}
-		if line == `\ No newline at end of file` {
-			w.Addr("$")
-			w.Write("data", []byte("\n"))
-			continue
+		if len(new) == 0 && !opt.allowEmpty {
+			// Most likely the formatter is broken; applying its
+			// output would wipe the window.
+			fail = errors.New("formatter output is empty")
+			log.Printf("skipped update to %s: %v", name, fail)
+			return false
 		}
-		if line[0] == '<' || line[0] == '-' || line[0] == '>' {
-			continue
+
+		edits, err = diffEdits(src, old, new, opt.ignoreSpace)
+		if err != nil {
+			fail = err
+			log.Print(err)
+			return false
 		}
-		j := 0
-		for j < len(line) && line[j] != 'a' && line[j] != 'c' && line[j] != 'd' {
-			j++
+
+		latest, err := w.ReadAll("body")
+		if err != nil {
+			fail = err
+			log.Print(err)
+			return false
 		}
-		if j >= len(line) {
-			log.Printf("cannot parse diff line: %q", line)
+		if bytes.Equal(old, latest) {
 			break
 		}
-		oldStart, oldEnd := parseSpan(line[:j])
-		newStart, newEnd := parseSpan(line[j+1:])
-		if oldStart == 0 || newStart == 0 {
-			continue
+		if !*retryModified || retried {
+			fail = errors.New("window modified since Put")
+			log.Printf("skipped update to %s: %v", name, fail)
+			return false
 		}
-		switch line[j] {
-		case 'a':
-			err := w.Addr("%d+#0", oldStart)
-			if err != nil {
-				log.Print(err)
-				break
-			}
-			w.Write("data", findLines(new, newStart, newEnd))
-		case 'c':
-			err := w.Addr("%d,%d", oldStart, oldEnd)
-			if err != nil {
-				log.Print(err)
-				break
-			}
+		// Format what the window holds now instead. The copy lives
+		// next to the file so that formatters find the same package
+		// and configuration files.
+		tmp, err := tempFile(filepath.Dir(name), ".acmego-*"+filepath.Ext(name), latest)
+		if err != nil {
+			fail = err
+			log.Print(err)
+			return false
+		}
+		defer os.Remove(tmp)
+		src = tmp
+		old = latest
+	}
 
-			w.Write("data", findLines(new, newStart, newEnd))
-		case 'd':
-			err := w.Addr("%d,%d", oldStart, oldEnd)
-			if err != nil {
-				log.Print(err)
-				break
-			}
+	if *checkIdem {
+		checkIdempotent(name, fmter, new)
+	}
 
-			w.Write("data", nil)
+	if *dryRun {
+		for _, cmd := range describeEdits(selectEdits(name, old, edits)) {
+			log.Printf("%s: would apply %s", name, cmd)
 		}
+		return false
+	}
+
+	if *preview {
+		showFormatted(name, new)
+		opt.infof("formatted %s into %s+formatted", name, name)
+		return false
+	}
+
+	edits = selectEdits(name, old, edits)
+	if *pickHunks && len(edits) > 0 {
+		pickEdits(id, name, old, edits)
+		opt.infof("%s: %d hunks to pick from in %s+hunks", name, len(edits), name)
+		return false
+	}
+	if *maxHunks > 0 && len(edits) > *maxHunks && !opt.ignoreSpace && !*changedOnly {
+		// Applying so many edits one by one is slow. Replacing the
+		// whole body is quick, at the cost of the dot, which keeps
+		// only its line and column, but is only right when the
+		// edits are all of the changes.
+		log.Printf("%s: %d edits is over the limit of %d, replacing the whole body", name, len(edits), *maxHunks)
+		edits = []edit{{addr: "0,$", data: new}}
+	}
+	if len(edits) == 0 {
+		return false
+	}
+
+	saved.save(name, old)
+	q0, q1, dotErr := w.readDot()
+	w.apply(edits)
+	if dotErr == nil {
+		// Acme moves the dot to the end of the last edit; put it
+		// back on the text it was on.
+		w.setDot(shiftDot(old, edits, q0), shiftDot(old, edits, q1))
 	}
+	hunks = len(edits)
+	opt.infof("formatted %s: %d edits", name, len(edits))
 	return w.modified
 }
 
+// selectEdits returns the edits to make to old, the body of the window
+// of file: with -changed only those on lines that differ from HEAD,
+// and with -mergegap those close together merged.
+func selectEdits(file string, old []byte, edits []edit) []edit {
+	if *changedOnly {
+		if ranges, ok := changedLines(file); ok {
+			edits = editsInRanges(edits, ranges)
+		}
+	}
+	if *mergeGap > 0 {
+		edits = mergeEdits(edits, old, *mergeGap)
+	}
+	return edits
+}
+
 // Encapsulates an Acme window along with its current state, modified or not.
 // This will allow us to execute additional fmt tools like bl2plus once the
 // window has been saved (not modified) and the original formatter has done
 // its job.
 type Window struct {
-	*acme.Win
+	acmeWin
 	modified bool
 }
 
+// acmeWin is the part of *acme.Win that reformat uses, so that tests
+// can stand in a fake window for a real one.
+type acmeWin interface {
+	Addr(format string, args ...interface{}) error
+	Ctl(format string, args ...interface{}) error
+	ReadAddr() (q0, q1 int, err error)
+	ReadAll(file string) ([]byte, error)
+	Write(file string, b []byte) (int, error)
+	CloseFiles()
+}
+
+// openWin opens the acme window with the given id.
+var openWin = func(id int) (acmeWin, error) {
+	return acme.Open(id, nil)
+}
+
 func (w *Window) Write(ftype string, data []byte) {
-	w.Win.Write(ftype, data)
+	w.acmeWin.Write(ftype, data)
 	w.modified = true
 }
 
-func parseSpan(text string) (start, end int) {
-	i := strings.Index(text, ",")
-	if i < 0 {
-		n, err := strconv.Atoi(text)
-		if err != nil {
-			log.Printf("cannot parse span %q", text)
-			return 0, 0
-		}
-		return n, n
+// readDot returns the dot of w, as rune offsets in the body.
+func (w *Window) readDot() (q0, q1 int, err error) {
+	// Open the addr file first, so that addr=dot holds for the read.
+	if _, _, err := w.ReadAddr(); err != nil {
+		return 0, 0, err
 	}
-	start, err1 := strconv.Atoi(text[:i])
-	end, err2 := strconv.Atoi(text[i+1:])
-	if err1 != nil || err2 != nil {
-		log.Printf("cannot parse span %q", text)
-		return 0, 0
+	if err := w.Ctl("addr=dot"); err != nil {
+		return 0, 0, err
 	}
-	return start, end
+	return w.ReadAddr()
 }
 
-func findLines(text []byte, start, end int) []byte {
-	i := 0
+// setDot sets the dot of w to the runes q0 through q1 of the body.
+func (w *Window) setDot(q0, q1 int) {
+	if q1 < q0 {
+		q1 = q0
+	}
+	if err := w.Addr("#%d,#%d", q0, q1); err != nil {
+		log.Print(err)
+		return
+	}
+	w.Ctl("dot=addr")
+}
 
-	start--
-	for ; i < len(text) && start > 0; i++ {
-		if text[i] == '\n' {
-			start--
-			end--
+// apply makes the edits to the body of w as a single undo step.
+// In acme a "mark" control message starts a new undo step and
+// "nomark" stops each write to the data file from starting one of its
+// own, so one Undo reverts all the edits. Acme turns marking back on
+// when the data file is closed, which apply does before returning, so
+// that the next change to the window starts a step of its own instead
+// of joining this one.
+func (w *Window) apply(edits []edit) {
+	w.Write("ctl", []byte("mark"))
+	w.Write("ctl", []byte("nomark"))
+	for _, e := range edits {
+		if err := w.Addr("%s", e.addr); err != nil {
+			log.Print(err)
+			continue
 		}
+		w.Write("data", e.data)
 	}
-	startByte := i
-	for ; i < len(text) && end > 0; i++ {
-		if text[i] == '\n' {
-			end--
-		}
+	w.CloseFiles()
+}
+
+// checkIdempotent formats out, the output of fmter for file, once more
+// and warns in the +Errors window if the second pass changes it.
+func checkIdempotent(file string, fmter Formatter, out []byte) {
+	tmp, err := tempFile(filepath.Dir(file), ".acmego-*"+filepath.Ext(file), out)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer os.Remove(tmp)
+	again, err := fmter.format(tmp)
+	if err != nil {
+		errorf(file, "acmego: %s: formatting the formatted file failed: %v", file, err)
+		return
+	}
+	if !bytes.Equal(out, again) {
+		errorf(file, "acmego: %s: formatter is not idempotent: a second pass changes its output", file)
+	}
+}
+
+// tempFile creates a new temporary file in dir holding data and
+// returns its name. The pattern is as for ioutil.TempFile.
+func tempFile(dir, pattern string, data []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
 	}
-	endByte := i
-	return text[startByte:endByte]
+	return f.Name(), nil
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// fakeWin is an acmeWin that holds a body and records what is done to it.
+type fakeWin struct {
+	body   []byte
+	ops    []string
+	closed bool
+	dot    *[2]int // nil if the addr file cannot be read
+}
+
+func (w *fakeWin) Addr(format string, args ...interface{}) error {
+	w.ops = append(w.ops, "addr "+fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (w *fakeWin) Ctl(format string, args ...interface{}) error {
+	w.ops = append(w.ops, "ctl "+fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (w *fakeWin) ReadAddr() (q0, q1 int, err error) {
+	if w.dot == nil {
+		return 0, 0, errors.New("fakeWin: no addr")
+	}
+	return w.dot[0], w.dot[1], nil
+}
+
+func (w *fakeWin) ReadAll(file string) ([]byte, error) {
+	if file != "body" {
+		return nil, fmt.Errorf("fakeWin: cannot read %s", file)
+	}
+	return w.body, nil
+}
+
+func (w *fakeWin) Write(file string, b []byte) (int, error) {
+	w.ops = append(w.ops, file+" "+string(b))
+	return len(b), nil
+}
+
+func (w *fakeWin) CloseFiles() {
+	w.closed = true
+}
+
+// fakeFmt is a Formatter that returns canned output.
+type fakeFmt struct {
+	out []byte
+	err error
+}
+
+func (f *fakeFmt) format(file string) ([]byte, error) {
+	return f.out, f.err
+}
+
+// withFakeWin runs f with openWin returning w and runDiff returning diff.
+func withFakeWin(w *fakeWin, diff string, f func()) {
+	defer func(o func(int) (acmeWin, error)) { openWin = o }(openWin)
+	defer func(d func(string, []byte) ([]byte, error)) { runDiff = d }(runDiff)
+	openWin = func(id int) (acmeWin, error) { return w, nil }
+	runDiff = func(file string, new []byte) ([]byte, error) { return []byte(diff), nil }
+	f()
+}
+
+func writeTemp(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "file.go")
+	if err := ioutil.WriteFile(name, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReformat(t *testing.T) {
+	for _, test := range diffTests {
+		name := writeTemp(t, test.old)
+		defer os.RemoveAll(filepath.Dir(name))
+		w := &fakeWin{body: []byte(test.old)}
+		var modified bool
+		withFakeWin(w, test.diff, func() {
+			modified = reformat(1, name, &fakeFmt{out: []byte(test.new)}, options{})
+		})
+		want := []string{"ctl mark", "ctl nomark"}
+		for _, e := range test.edits {
+			want = append(want, "addr "+e.addr, "data "+string(e.data))
+		}
+		if !reflect.DeepEqual(w.ops, want) {
+			t.Errorf("%s: ops = %q, want %q", test.name, w.ops, want)
+		}
+		if !modified {
+			t.Errorf("%s: reformat reported window not modified", test.name)
+		}
+		if !w.closed {
+			t.Errorf("%s: window files not closed", test.name)
+		}
+	}
+}
+
+func TestReformatMaxHunks(t *testing.T) {
+	defer func(n int) { *maxHunks = n }(*maxHunks)
+	*maxHunks = 1
+	name := writeTemp(t, "a\nb\nc\nd\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\nc\nd\n")}
+	withFakeWin(w, "1c1\n< a\n---\n> A\n4d3\n< d\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("A\nb\nc\n")}, options{})
+	})
+	want := []string{"ctl mark", "ctl nomark", "addr 0,$", "data A\nb\nc\n"}
+	if !reflect.DeepEqual(w.ops, want) {
+		t.Errorf("ops = %q, want %q", w.ops, want)
+	}
+}
+
+// TestReformatDot checks that the dot stays on the text it was on when
+// lines are added above it, rather than move to the end of the edit.
+func TestReformatDot(t *testing.T) {
+	name := writeTemp(t, "a\nb\nc\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\nc\n"), dot: &[2]int{4, 5}} // c
+	withFakeWin(w, "0a1,2\n> x\n> y\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("x\ny\na\nb\nc\n")}, options{})
+	})
+	want := []string{"ctl addr=dot", "ctl mark", "ctl nomark", "addr #0", "data x\ny\n", "addr #8,#9", "ctl dot=addr"}
+	if !reflect.DeepEqual(w.ops, want) {
+		t.Errorf("ops = %q, want %q", w.ops, want)
+	}
+}
+
+func TestReformatDryRun(t *testing.T) {
+	defer func(n bool) { *dryRun = n }(*dryRun)
+	*dryRun = true
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\n"), dot: &[2]int{0, 0}}
+	var modified bool
+	withFakeWin(w, "2c2\n< b\n---\n> B\n", func() {
+		modified = reformat(1, name, &fakeFmt{out: []byte("a\nB\n")}, options{})
+	})
+	if len(w.ops) != 0 || modified {
+		t.Errorf("dry run: ops = %q, modified = %v, want no ops, not modified", w.ops, modified)
+	}
+
+	defer func(e bool) { *errDot = e }(*errDot)
+	*errDot = true
+	w = &fakeWin{body: []byte("a\nb\n"), dot: &[2]int{0, 0}}
+	withFakeWin(w, "", func() {
+		reformat(1, name, &fakeFmt{out: []byte(name + ":2:1: syntax error\n"), err: errors.New("exit status 2")}, options{})
+	})
+	if len(w.ops) != 0 {
+		t.Errorf("dry run with -errdot: ops = %q, want none", w.ops)
+	}
+}
+
+func TestReformatNoChange(t *testing.T) {
+	name := writeTemp(t, "a\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\n")}
+	withFakeWin(w, "", func() {
+		if reformat(1, name, &fakeFmt{out: []byte("a\n")}, options{}) {
+			t.Error("reformat reported window modified")
+		}
+	})
+	if len(w.ops) != 0 {
+		t.Errorf("ops = %q, want none", w.ops)
+	}
+}
+
+func TestReformatWindowModified(t *testing.T) {
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\nc\n")}
+	withFakeWin(w, "2c2\n< b\n---\n> B\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("a\nB\n")}, options{})
+	})
+	if len(w.ops) != 0 {
+		t.Errorf("ops = %q, want none", w.ops)
+	}
+}
+
+func TestReformatError(t *testing.T) {
+	defer func(b bool) { *errDot = b }(*errDot)
+	*errDot = true
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\n")}
+	out := []byte(name + ":2:1: expected ';'\n")
+	withFakeWin(w, "", func() {
+		reformat(1, name, &fakeFmt{out: out, err: errors.New("exit status 2")}, options{})
+	})
+	want := []string{"addr 2", "ctl dot=addr", "ctl show"}
+	if !reflect.DeepEqual(w.ops, want) {
+		t.Errorf("ops = %q, want %q", w.ops, want)
+	}
+}
+
+func TestReformatTooLarge(t *testing.T) {
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("a\nb\n")}
+	withFakeWin(w, "2c2\n< b\n---\n> B\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("a\nB\n")}, options{maxSize: 3})
+	})
+	if len(w.ops) != 0 {
+		t.Errorf("ops = %q, want none", w.ops)
+	}
+}
+
+func TestReformatEmpty(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		name := writeTemp(t, "a\n")
+		defer os.RemoveAll(filepath.Dir(name))
+		w := &fakeWin{body: []byte("a\n")}
+		withFakeWin(w, "1d0\n< a\n", func() {
+			reformat(1, name, &fakeFmt{out: []byte{}}, options{allowEmpty: allow})
+		})
+		if edited := len(w.ops) > 0; edited != allow {
+			t.Errorf("allowEmpty %v: ops = %q", allow, w.ops)
+		}
+	}
+}
+
+func TestReformatGenerated(t *testing.T) {
+	marker := regexp.MustCompile(*genMarker)
+	for _, src := range []string{
+		"// Code generated by stringer; DO NOT EDIT.\n\npackage p\n",
+		"// Copyright 2024 A. Author\n\n// Code generated by x. DO NOT EDIT.\npackage p\n",
+		"#!/bin/sh\n# Code generated by y. DO NOT EDIT.\n",
+	} {
+		name := writeTemp(t, src)
+		defer os.RemoveAll(filepath.Dir(name))
+		w := &fakeWin{body: []byte(src)}
+		withFakeWin(w, "1d0\n< x\n", func() {
+			reformat(1, name, &fakeFmt{out: []byte("x\n")}, options{generated: marker})
+		})
+		if len(w.ops) != 0 {
+			t.Errorf("%q: ops = %q, want none", src, w.ops)
+		}
+	}
+	if src := "package p\n\n// Code generated by hand. DO NOT EDIT.\n"; isGenerated([]byte(src), marker) {
+		t.Errorf("%q reported generated", src)
+	}
+}
+
+func TestReformatBody(t *testing.T) {
+	name := writeTemp(t, "saved\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	w := &fakeWin{body: []byte("x\n")}
+	withFakeWin(w, "1c1\n< x\n---\n> X\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("X\n")}, options{body: true})
+	})
+	want := []string{"ctl mark", "ctl nomark", "addr 1,1", "data X\n"}
+	if !reflect.DeepEqual(w.ops, want) {
+		t.Errorf("ops = %q, want %q", w.ops, want)
+	}
+}
+
+// TestReformatBodyChecks checks that Fmt formats the body whatever the
+// file on disk is, and applies the limits to the body instead.
+func TestReformatBodyChecks(t *testing.T) {
+	name := writeTemp(t, "saved\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	if err := os.Chmod(name, 0444); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{name, filepath.Join(filepath.Dir(name), "unsaved.go")} {
+		w := &fakeWin{body: []byte("x\n")}
+		withFakeWin(w, "1c1\n< x\n---\n> X\n", func() {
+			reformat(1, file, &fakeFmt{out: []byte("X\n")}, options{body: true})
+		})
+		if len(w.ops) == 0 {
+			t.Errorf("%s: body not formatted", file)
+		}
+	}
+
+	marker := regexp.MustCompile(*genMarker)
+	for _, test := range []struct {
+		body string
+		opt  options
+	}{
+		{"a\nb\n", options{body: true, maxSize: 3}},
+		{"// Code generated by x. DO NOT EDIT.\n", options{body: true, generated: marker}},
+	} {
+		w := &fakeWin{body: []byte(test.body)}
+		withFakeWin(w, "1d0\n< x\n", func() {
+			reformat(1, name, &fakeFmt{out: []byte("x\n")}, test.opt)
+		})
+		if len(w.ops) != 0 {
+			t.Errorf("%q: ops = %q, want none", test.body, w.ops)
+		}
+	}
+}
+
+func TestReformatBackup(t *testing.T) {
+	defer func(b backups) { saved = b }(saved)
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved = backups{dir: dir, keep: 2}
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	for i := 0; i < 3; i++ {
+		w := &fakeWin{body: []byte("a\nb\n")}
+		withFakeWin(w, "2c2\n< b\n---\n> B\n", func() {
+			reformat(1, name, &fakeFmt{out: []byte("a\nB\n")}, options{})
+		})
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("backups = %q, %v, want 2", files, err)
+	}
+	if data, _ := ioutil.ReadFile(files[1]); string(data) != "a\nb\n" {
+		t.Errorf("backup holds %q, want %q", data, "a\nb\n")
+	}
+}
+
+// TestBackupsOtherFiles checks that saving the backups of a file does
+// not prune those of another file whose name starts with its name.
+func TestBackupsOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := &backups{dir: dir, keep: 1}
+	b.save("/src/b.go.orig", []byte("orig\n"))
+	for i := 0; i < 3; i++ {
+		b.save("/src/b.go", []byte("b\n"))
+	}
+	orig, _ := filepath.Glob(filepath.Join(dir, "%src%b.go.orig.*.bak"))
+	all, _ := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if len(orig) != 1 || len(all) != 2 {
+		t.Errorf("backups = %q, want one of each file", all)
+	}
+}
+
+func TestReformatEvents(t *testing.T) {
+	defer func(l *eventLog) { fmtEvents = l }(fmtEvents)
+	var buf bytes.Buffer
+	fmtEvents = &eventLog{enc: json.NewEncoder(&buf)}
+	name := writeTemp(t, "a\nb\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	withFakeWin(&fakeWin{body: []byte("a\nb\n")}, "2c2\n< b\n---\n> B\n", func() {
+		reformat(1, name, &fakeFmt{out: []byte("a\nB\n")}, options{})
+		reformat(1, name, &LimitFmt{fmter: &fakeFmt{err: errors.New("syntax error")}, sem: make(chan struct{}, 1)}, options{})
+	})
+	want := []fmtEvent{
+		{File: name, Ext: "go", Formatter: "fakeFmt", Hunks: 1, Changed: true},
+		{File: name, Ext: "go", Formatter: "fakeFmt", Error: "syntax error"},
+	}
+	dec := json.NewDecoder(&buf)
+	for _, w := range want {
+		var ev fmtEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		ev.Duration = 0
+		if ev != w {
+			t.Errorf("event = %+v, want %+v", ev, w)
+		}
+	}
+	if dec.More() {
+		t.Errorf("more events than %d", len(want))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// MakeFmt tidies Makefiles in-process. Since make tells recipe lines
+// apart by the tab that starts them, the lines indented with blanks
+// in the recipe of a rule get a tab in their place; lines that start
+// with a tab, continue the line before or are within a define block
+// are never changed. Variable assignments at the start of a line get
+// one blank on each side of the operator. Neither is done in a file
+// that sets .RECIPEPREFIX. Conditionals and define blocks that are not
+// closed, and those closed that were never opened, are reported as
+// errors.
+type MakeFmt struct{}
+
+func (*MakeFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	new, err := tidyMakefile(src)
+	if err != nil {
+		fmtError(file, "makefmt", err, nil)
+	}
+	return new, err
+}
+
+// assignRE matches a variable assignment: the modifiers, the name,
+// the operator and the value.
+var assignRE = regexp.MustCompile(`^((?:(?:override|export|private)[ \t]+)*)([A-Za-z0-9_.-]+)[ \t]*(:::=|::=|:=|\?=|\+=|!=|=)[ \t]*(.*)$`)
+
+func tidyMakefile(src []byte) ([]byte, error) {
+	if strings.Contains(string(src), ".RECIPEPREFIX") {
+		return src, nil
+	}
+	var (
+		buf    strings.Builder
+		recipe bool  // in the recipe of a rule
+		cont   bool  // the line continues the one before
+		define int   // line of the open define block, if not 0
+		conds  []int // lines of the open conditionals
+	)
+	for i, line := range strings.SplitAfter(string(src), "\n") {
+		n := i + 1
+		text := strings.TrimRight(line, "\r\n")
+		eol := line[len(text):]
+		trimmed := strings.TrimLeft(text, " \t")
+		word := firstWord(trimmed)
+		wasCont := cont
+		cont = continues(text)
+		switch {
+		case wasCont:
+		case define > 0:
+			if word == "endef" {
+				define = 0
+			}
+		case recipe && strings.HasPrefix(text, "\t"):
+			// A line of the recipe, for the shell.
+		case trimmed == "" || trimmed[0] == '#':
+			// Blank lines and comments may come between the
+			// lines of a recipe.
+		case word == "ifeq" || word == "ifneq" || word == "ifdef" || word == "ifndef":
+			conds = append(conds, n)
+		case word == "else":
+			if len(conds) == 0 {
+				return nil, fmt.Errorf("line %d: else without a conditional", n)
+			}
+		case word == "endif":
+			if len(conds) == 0 {
+				return nil, fmt.Errorf("line %d: endif without a conditional", n)
+			}
+			conds = conds[:len(conds)-1]
+		case text[0] == ' ' || text[0] == '\t':
+			if recipe {
+				text = "\t" + trimmed
+			}
+		case isDefine(text):
+			define, recipe = n, false
+		default:
+			if m := assignRE.FindStringSubmatch(text); m != nil {
+				text = m[1] + m[2] + " " + m[3]
+				if m[4] != "" {
+					text += " " + m[4]
+				}
+				recipe = false
+			} else {
+				recipe = isRule(text)
+			}
+		}
+		buf.WriteString(text + eol)
+	}
+	if define > 0 {
+		return nil, fmt.Errorf("line %d: define without endef", define)
+	}
+	if len(conds) > 0 {
+		return nil, fmt.Errorf("line %d: conditional without endif", conds[len(conds)-1])
+	}
+	return []byte(buf.String()), nil
+}
+
+func firstWord(s string) string {
+	if i := strings.IndexAny(s, " \t("); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// continues reports whether line ends in a backslash that continues
+// it onto the next line, rather than an escaped one.
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// isDefine reports whether line starts a define block.
+func isDefine(line string) bool {
+	for _, f := range strings.Fields(line) {
+		switch f {
+		case "override", "export", "private":
+			continue
+		case "define":
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// isRule reports whether line, which starts with neither a blank nor a
+// directive, is a rule: targets followed by a colon, and then neither
+// an assignment operator nor a target-specific variable.
+func isRule(line string) bool {
+	line = topLevel(line)
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+	i := strings.Index(line, ":")
+	if i < 0 || strings.Contains(line[:i], "=") {
+		return false
+	}
+	return !strings.Contains(line[i+1:], "=")
+}
+
+// topLevel returns line with the text of the variable references and
+// function calls in it, $(...) and ${...}, turned into blanks, so that
+// the colons and equal signs in them are not taken for make's own.
+func topLevel(line string) string {
+	b := []byte(line)
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '$' && i+1 < len(b) && (b[i+1] == '(' || b[i+1] == '{'):
+			depth++
+			i++
+			b[i] = ' '
+		case depth > 0 && (b[i] == '(' || b[i] == '{'):
+			depth++
+		case depth > 0 && (b[i] == ')' || b[i] == '}'):
+			depth--
+		}
+		if depth > 0 {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+var makeTests = []struct {
+	name     string
+	src, out string
+}{
+	{
+		name: "recipe indented with blanks",
+		src:  "all: a b\n    cc -o all a b\n\n  # note\n\t@echo done\n",
+		out:  "all: a b\n\tcc -o all a b\n\n  # note\n\t@echo done\n",
+	},
+	{
+		name: "assignments",
+		src:  "CC=gcc\nCFLAGS  +=   -O2\nX:=$(shell a:b)\nexport Y ?=\nZ = a:b \n",
+		out:  "CC = gcc\nCFLAGS += -O2\nX := $(shell a:b)\nexport Y ?=\nZ = a:b \n",
+	},
+	{
+		name: "blanks outside a recipe",
+		src:  "X = a \\\n    b\nifdef X\n  Y=1\nendif\n",
+		out:  "X = a \\\n    b\nifdef X\n  Y=1\nendif\n",
+	},
+	{
+		name: "continued recipe line",
+		src:  "t:\n\tfor i in 1 2; do \\\n  echo $$i; \\\n  done\n",
+		out:  "t:\n\tfor i in 1 2; do \\\n  echo $$i; \\\n  done\n",
+	},
+	{
+		name: "assignment ends the recipe",
+		src:  "t:\n  a\nV=1\n  b\n",
+		out:  "t:\n\ta\nV = 1\n  b\n",
+	},
+	{
+		name: "target-specific variable",
+		src:  "t: V=1\n  b\n",
+		out:  "t: V=1\n  b\n",
+	},
+	{
+		name: "conditional in a recipe",
+		src:  "t:\n  a\nifeq ($(X),1)\n  b\n  else\n  c\nendif\n",
+		out:  "t:\n\ta\nifeq ($(X),1)\n\tb\n  else\n\tc\nendif\n",
+	},
+	{
+		name: "define block",
+		src:  "define F\nX=1\n  y\nendef\nt:\n  z\n",
+		out:  "define F\nX=1\n  y\nendef\nt:\n\tz\n",
+	},
+	{
+		name: "recipe prefix",
+		src:  ".RECIPEPREFIX = >\nt:\n  a\nX=1\n",
+		out:  ".RECIPEPREFIX = >\nt:\n  a\nX=1\n",
+	},
+	{
+		name: "CR LF",
+		src:  "t:\r\n  a\r\n",
+		out:  "t:\r\n\ta\r\n",
+	},
+}
+
+func TestTidyMakefile(t *testing.T) {
+	for _, test := range makeTests {
+		out, err := tidyMakefile([]byte(test.src))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(out) != test.out {
+			t.Errorf("%s:\n%q\nwant:\n%q", test.name, out, test.out)
+			continue
+		}
+		if again, _ := tidyMakefile(out); string(again) != string(out) {
+			t.Errorf("%s: not idempotent:\n%q", test.name, again)
+		}
+	}
+}
+
+func TestTidyMakefileError(t *testing.T) {
+	for _, src := range []string{
+		"ifdef X\nY = 1\n",
+		"endif\n",
+		"else\n",
+		"define F\nx\n",
+	} {
+		if out, err := tidyMakefile([]byte(src)); err == nil {
+			t.Errorf("tidyMakefile(%q) = %q, want error", src, out)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MdFmt tidies Markdown: it aligns the columns of pipe tables, sorts
+// runs of link reference definitions and, where all their labels are
+// numbers, renumbers them in the order the text refers to them, and,
+// if width is positive, reflows plain paragraphs to that many columns.
+// The formatter is implemented in-process and only rewrites what it
+// recognizes: code blocks, HTML, and anything it is unsure about are
+// left as they are, so odd Markdown comes back unchanged.
+type MdFmt struct {
+	width int
+}
+
+func (m *MdFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return m.tidy(src), nil
+}
+
+// Kinds of Markdown blocks.
+const (
+	mdOther = iota // headings, lists, quotes: kept, but links renumbered
+	mdRaw          // indented code and HTML: kept as is
+	mdCode         // fenced code: kept as is
+	mdPara         // plain paragraph
+	mdTable        // pipe table
+	mdDefs         // run of link reference definitions
+)
+
+type mdBlock struct {
+	kind  int
+	lines []string
+}
+
+func (m *MdFmt) tidy(src []byte) []byte {
+	text := string(src)
+	if text == "" || strings.Contains(text, "\r") {
+		return src
+	}
+	final := strings.HasSuffix(text, "\n")
+	blocks := mdBlocks(strings.Split(strings.TrimSuffix(text, "\n"), "\n"))
+	renumberRefs(blocks)
+	var out []string
+	for i, b := range blocks {
+		switch b.kind {
+		case mdPara:
+			out = append(out, m.reflow(b.lines)...)
+		case mdTable:
+			out = append(out, alignTable(b.lines)...)
+		case mdDefs:
+			// A definition may have its title on the next line;
+			// sorting would give it to another label.
+			if i+1 == len(blocks) || strings.TrimSpace(blocks[i+1].lines[0]) == "" {
+				sortDefs(b.lines)
+			}
+			out = append(out, b.lines...)
+		default:
+			out = append(out, b.lines...)
+		}
+	}
+	res := strings.Join(out, "\n")
+	if final {
+		res += "\n"
+	}
+	return []byte(res)
+}
+
+var (
+	fenceRE     = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	refDefRE    = regexp.MustCompile(`^ {0,3}\[([^\[\]]+)\]:(\s|$)`)
+	delimCellRE = regexp.MustCompile(`^:?-+:?$`)
+	listRE      = regexp.MustCompile(`^([-+*]|\d{1,9}[.)])(\s|$)`)
+	breakRE     = regexp.MustCompile(`^ {0,3}([-*_])( *[-*_])*\s*$`)
+)
+
+// mdBlocks splits the lines of a Markdown document into blocks.
+// Blank lines are blocks of their own.
+func mdBlocks(lines []string) []mdBlock {
+	var blocks []mdBlock
+	add := func(kind int, lines []string) {
+		blocks = append(blocks, mdBlock{kind, lines})
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		j := i + 1
+		switch {
+		case fenceRE.MatchString(line):
+			fence := strings.TrimLeft(fenceRE.FindStringSubmatch(line)[1], " ")
+			for j < len(lines) && !closesFence(lines[j], fence) {
+				j++
+			}
+			if j < len(lines) {
+				j++
+			}
+			add(mdCode, lines[i:j])
+		case isTableStart(lines, i):
+			j = i + 2
+			for j < len(lines) && strings.Contains(lines[j], "|") && !fenceRE.MatchString(lines[j]) {
+				j++
+			}
+			add(mdTable, lines[i:j])
+		case refDefRE.MatchString(line):
+			for j < len(lines) && refDefRE.MatchString(lines[j]) {
+				j++
+			}
+			add(mdDefs, lines[i:j])
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(strings.TrimLeft(line, " "), "<"):
+			add(mdRaw, lines[i:j])
+		case isParaLine(line) && (i == 0 || strings.TrimSpace(lines[i-1]) == ""):
+			for j < len(lines) && isParaLine(lines[j]) && !isTableStart(lines, j) {
+				j++
+			}
+			add(mdPara, lines[i:j])
+		default:
+			add(mdOther, lines[i:j])
+		}
+		i = j
+	}
+	return blocks
+}
+
+// closesFence reports whether line ends a code block opened by fence.
+func closesFence(line, fence string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// isParaLine reports whether line can be part of a plain paragraph:
+// it is not blank, not indented, and does not start another block.
+func isParaLine(line string) bool {
+	if line == "" || line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+	switch line[0] {
+	case '#', '>', '|', '<', '=':
+		return false
+	}
+	return !listRE.MatchString(line) && !breakRE.MatchString(line) && !fenceRE.MatchString(line)
+}
+
+// reflow fills the paragraph lines to m.width columns. Paragraphs
+// with hard line breaks or with spacing that may be significant in a
+// code span are left alone.
+func (m *MdFmt) reflow(lines []string) []string {
+	if m.width <= 0 {
+		return lines
+	}
+	text := strings.Join(lines, "\n")
+	if strings.Contains(text, "\t") || strings.Contains(text, "`") && strings.Contains(text, "  ") {
+		return lines
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\") {
+			return lines
+		}
+	}
+	var out []string
+	cur := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case cur == "":
+			cur = word
+		case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(word) <= m.width || !isParaLine(word):
+			// A word that would start a new block stays on the line.
+			cur += " " + word
+		default:
+			out = append(out, cur)
+			cur = word
+		}
+	}
+	return append(out, cur)
+}
+
+// isTableStart reports whether a pipe table starts at lines[i]:
+// a header row followed by a delimiter row with as many cells.
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t") {
+		return false
+	}
+	delims := splitRow(lines[i+1])
+	if len(delims) != len(splitRow(lines[i])) {
+		return false
+	}
+	for _, d := range delims {
+		if !delimCellRE.MatchString(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRow splits a table row into its trimmed cells.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[start:]))
+}
+
+// alignTable pads the cells of a pipe table so that its columns line
+// up, respecting the alignment given in the delimiter row. Tables with
+// rows longer than the header are left alone.
+func alignTable(lines []string) []string {
+	rows := make([][]string, len(lines))
+	for i, line := range lines {
+		rows[i] = splitRow(line)
+	}
+	n := len(rows[0])
+	widths := make([]int, n)
+	for i, row := range rows {
+		if len(row) > n {
+			return lines
+		}
+		for len(row) < n {
+			row = append(row, "")
+		}
+		rows[i] = row
+		for j, cell := range row {
+			if i != 1 && utf8.RuneCountInString(cell) > widths[j] {
+				widths[j] = utf8.RuneCountInString(cell)
+			}
+		}
+	}
+	align := make([]string, n)
+	for j, d := range rows[1] {
+		if widths[j] < 3 {
+			widths[j] = 3
+		}
+		switch {
+		case strings.HasPrefix(d, ":") && strings.HasSuffix(d, ":") && len(d) > 1:
+			align[j] = "center"
+			rows[1][j] = ":" + strings.Repeat("-", widths[j]-2) + ":"
+		case strings.HasPrefix(d, ":"):
+			align[j] = "left"
+			rows[1][j] = ":" + strings.Repeat("-", widths[j]-1)
+		case strings.HasSuffix(d, ":"):
+			align[j] = "right"
+			rows[1][j] = strings.Repeat("-", widths[j]-1) + ":"
+		default:
+			rows[1][j] = strings.Repeat("-", widths[j])
+		}
+	}
+	out := make([]string, len(rows))
+	for i, row := range rows {
+		cells := make([]string, n)
+		for j, cell := range row {
+			pad := widths[j] - utf8.RuneCountInString(cell)
+			switch align[j] {
+			case "right":
+				cell = strings.Repeat(" ", pad) + cell
+			case "center":
+				cell = strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+			default:
+				cell += strings.Repeat(" ", pad)
+			}
+			cells[j] = cell
+		}
+		out[i] = "| " + strings.Join(cells, " | ") + " |"
+	}
+	return out
+}
+
+// defLabel returns the normalized label of a link reference definition.
+func defLabel(line string) string {
+	return normLabel(refDefRE.FindStringSubmatch(line)[1])
+}
+
+// normLabel normalizes a link label the way Markdown matches labels.
+func normLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// sortDefs sorts a run of link reference definitions by label,
+// numbered labels first in numeric order.
+func sortDefs(defs []string) {
+	sort.SliceStable(defs, func(i, j int) bool {
+		li, lj := defLabel(defs[i]), defLabel(defs[j])
+		ni, erri := strconv.Atoi(li)
+		nj, errj := strconv.Atoi(lj)
+		switch {
+		case erri == nil && errj == nil:
+			return ni < nj
+		case erri == nil || errj == nil:
+			return erri == nil
+		}
+		return li < lj
+	})
+}
+
+// A refMatch is a use of a link reference label in text: the label
+// text[start:end].
+type refMatch struct {
+	start, end int
+	label      string
+}
+
+var refRE = regexp.MustCompile(`\[([^\[\]]+)\]`)
+
+// refMatches returns the uses in text of the labels in defined,
+// leaving out code spans and inline links.
+func refMatches(text string, defined map[string]bool) []refMatch {
+	code := codeSpans(text)
+	var refs []refMatch
+	for _, m := range refRE.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2], m[3]
+		if inSpans(code, m[0]) || m[0] > 0 && text[m[0]-1] == '\\' {
+			continue
+		}
+		rest := text[m[1]:]
+		if strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "[]") {
+			// Inline link, or the text of a full reference link.
+			continue
+		}
+		if label := normLabel(text[start:end]); defined[label] {
+			refs = append(refs, refMatch{start, end, label})
+		}
+	}
+	return refs
+}
+
+// codeSpans returns the byte ranges of the code spans in text.
+func codeSpans(text string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := 1
+		for i+n < len(text) && text[i+n] == '`' {
+			n++
+		}
+		end := -1
+		for j := i + n; j < len(text); {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+			k := 1
+			for j+k < len(text) && text[j+k] == '`' {
+				k++
+			}
+			if k == n {
+				end = j + k
+				break
+			}
+			j += k
+		}
+		if end < 0 {
+			i += n
+			continue
+		}
+		spans = append(spans, [2]int{i, end})
+		i = end
+	}
+	return spans
+}
+
+func inSpans(spans [][2]int, i int) bool {
+	for _, s := range spans {
+		if s[0] <= i && i < s[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// renumberRefs renumbers numbered link reference definitions in the
+// order in which the text first uses them, unused ones last. It does
+// nothing unless every label is a distinct number, or if a label is
+// used where it cannot tell whether it is Markdown, as in HTML.
+func renumberRefs(blocks []mdBlock) {
+	var labels []string
+	defined := make(map[string]bool)
+	for _, b := range blocks {
+		if b.kind != mdDefs {
+			continue
+		}
+		for _, line := range b.lines {
+			label := defLabel(line)
+			if _, err := strconv.Atoi(label); err != nil || defined[label] {
+				return
+			}
+			defined[label] = true
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return
+	}
+
+	renum := make(map[string]string)
+	use := func(label string) {
+		if renum[label] == "" {
+			renum[label] = strconv.Itoa(len(renum) + 1)
+		}
+	}
+	for _, b := range blocks {
+		switch b.kind {
+		case mdRaw:
+			if len(refMatches(strings.Join(b.lines, "\n"), defined)) > 0 {
+				return
+			}
+		case mdOther, mdPara, mdTable:
+			for _, r := range refMatches(strings.Join(b.lines, "\n"), defined) {
+				use(r.label)
+			}
+		}
+	}
+	for _, label := range labels {
+		use(label)
+	}
+
+	for i, b := range blocks {
+		switch b.kind {
+		case mdOther, mdPara, mdTable:
+			text := strings.Join(b.lines, "\n")
+			refs := refMatches(text, defined)
+			for k := len(refs) - 1; k >= 0; k-- {
+				r := refs[k]
+				text = text[:r.start] + renum[r.label] + text[r.end:]
+			}
+			blocks[i].lines = strings.Split(text, "\n")
+		case mdDefs:
+			for k, line := range b.lines {
+				m := refDefRE.FindStringSubmatchIndex(line)
+				b.lines[k] = line[:m[2]] + renum[defLabel(line)] + line[m[3]:]
+			}
+		}
+	}
+}
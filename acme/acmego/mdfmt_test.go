@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+var mdTests = []struct {
+	name     string
+	width    int
+	src, out string
+}{
+	{
+		name: "table",
+		src:  "| a | long header |\n|:-|--:|\n| wide cell | 1 |\n| x |\n",
+		out:  "| a         | long header |\n| :-------- | ----------: |\n| wide cell |           1 |\n| x         |             |\n",
+	},
+	{
+		name: "table without outer pipes",
+		src:  "a|b\n:-:|-\nc|d \\| e\n",
+		out:  "|  a  | b      |\n| :-: | ------ |\n|  c  | d \\| e |\n",
+	},
+	{
+		name: "table with extra cells",
+		src:  "| a |\n|---|\n| b | c |\n",
+		out:  "| a |\n|---|\n| b | c |\n",
+	},
+	{
+		name:  "reflow",
+		width: 20,
+		src:   "one two three four five six seven\neight\n\nnine\n",
+		out:   "one two three four\nfive six seven eight\n\nnine\n",
+	},
+	{
+		name:  "reflow keeps block markers off line starts",
+		width: 8,
+		src:   "abc def - ghi # 1. x\n",
+		out:   "abc def -\nghi # 1.\nx\n",
+	},
+	{
+		name:  "hard break",
+		width: 80,
+		src:   "a  \nb\n",
+		out:   "a  \nb\n",
+	},
+	{
+		name:  "lists and quotes",
+		width: 3,
+		src:   "- a b\n- c d\n\n> e f\n",
+		out:   "- a b\n- c d\n\n> e f\n",
+	},
+	{
+		name: "renumber links",
+		src:  "See [b][2], [1] and [c][]. `[2]` [x](u)\n\n[2]: http://b\n[1]: http://a\n[3]: http://c\n",
+		out:  "See [b][1], [2] and [c][]. `[2]` [x](u)\n\n[1]: http://b\n[2]: http://a\n[3]: http://c\n",
+	},
+	{
+		name: "sort named links",
+		src:  "[b] [a]\n\n[b]: http://b\n[a]: http://a\n",
+		out:  "[b] [a]\n\n[a]: http://a\n[b]: http://b\n",
+	},
+	{
+		name: "link with title on next line",
+		src:  "[b] [a]\n\n[b]: http://b\n[a]: http://a\n  \"title\"\n",
+		out:  "[b] [a]\n\n[b]: http://b\n[a]: http://a\n  \"title\"\n",
+	},
+	{
+		name: "link used in html",
+		src:  "[2]\n\n<p>[1]</p>\n\n[2]: http://b\n[1]: http://a\n",
+		out:  "[2]\n\n<p>[1]</p>\n\n[1]: http://a\n[2]: http://b\n",
+	},
+	{
+		name:  "fenced code",
+		width: 3,
+		src:   "```\n| a |\n|---|\nx [1] y\n```\n\n[1]\n\n[1]: u\n",
+		out:   "```\n| a |\n|---|\nx [1] y\n```\n\n[1]\n\n[1]: u\n",
+	},
+	{
+		name:  "unclosed fence",
+		width: 3,
+		src:   "~~~\na b c\n",
+		out:   "~~~\na b c\n",
+	},
+	{
+		name: "no final newline",
+		src:  "a|b\n-|-",
+		out:  "| a   | b   |\n| --- | --- |",
+	},
+}
+
+func TestMdFmt(t *testing.T) {
+	for _, test := range mdTests {
+		m := &MdFmt{width: test.width}
+		out := string(m.tidy([]byte(test.src)))
+		if out != test.out {
+			t.Errorf("%s:\n%s\nwant:\n%s", test.name, out, test.out)
+			continue
+		}
+		if again := string(m.tidy([]byte(out))); again != out {
+			t.Errorf("%s: not idempotent:\n%s", test.name, again)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !plan9 && !windows
+// +build !plan9,!windows
+
+package main
+
+import "syscall"
+
+// setNice sets the nice value of process pid.
+func setNice(pid, n int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, n)
+}
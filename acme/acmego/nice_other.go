@@ -0,0 +1,9 @@
+//go:build plan9 || windows
+// +build plan9 windows
+
+package main
+
+// setNice does nothing: there are no nice values on this system.
+func setNice(pid, n int) error {
+	return nil
+}
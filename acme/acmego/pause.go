@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// configFile returns the name of the file elem in the directory
+// holding acmego's configuration, $HOME/.config/acmego, or the empty
+// string if there is no home directory.
+func configFile(elem string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "acmego", elem)
+}
+
+// A pauser tells whether formatting is paused, which it is for as
+// long as the file at path exists. Creating the file stops acmego
+// from touching any window, for instance during a bulk paste, without
+// having to kill it.
+type pauser struct {
+	path   string
+	paused bool
+}
+
+// check reports whether formatting is paused, logging when that
+// changed since the last check.
+func (p *pauser) check() bool {
+	if p.path == "" {
+		return false
+	}
+	_, err := os.Stat(p.path)
+	paused := err == nil
+	if paused != p.paused {
+		if paused {
+			log.Printf("formatting paused: %s exists", p.path)
+		} else {
+			log.Printf("formatting resumed: %s removed", p.path)
+		}
+		p.paused = paused
+	}
+	return paused
+}
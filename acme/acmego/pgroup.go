@@ -0,0 +1,23 @@
+//go:build !plan9 && !windows
+// +build !plan9,!windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setGroup makes cmd start in a process group of its own, so that
+// killGroup kills whatever it starts as well.
+func setGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killGroup kills the process group of cmd, started after setGroup.
+func killGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
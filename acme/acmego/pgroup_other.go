@@ -0,0 +1,14 @@
+//go:build plan9 || windows
+// +build plan9 windows
+
+package main
+
+import "os/exec"
+
+// setGroup does nothing: process groups are not handled on this system.
+func setGroup(cmd *exec.Cmd) {}
+
+// killGroup kills cmd's process only.
+func killGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
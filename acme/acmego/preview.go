@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+
+	"9fans.net/go/acme"
+)
+
+// showFormatted shows out, the formatted contents of file, in a
+// window of its own named file+"+formatted", for comparison with the
+// window of the file, which is left as it is. The window is kept
+// open, as the +Errors window is, so that later results replace its
+// contents. The name has no blank in it, as acme takes the first word
+// of the tag for the name of a window.
+func showFormatted(file string, out []byte) {
+	name := file + "+formatted"
+	w := acme.Show(name)
+	if w == nil {
+		var err error
+		w, err = acme.New()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		w.Name("%s", name)
+	}
+	if err := w.Addr(","); err != nil {
+		log.Print(err)
+		return
+	}
+	w.Write("data", out)
+	w.Addr("#0")
+	w.Ctl("dot=addr")
+	w.Ctl("clean")
+	w.Ctl("show")
+}
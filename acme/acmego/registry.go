@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A registry holds the formatters to use for each file extension,
+// and for the files that are known by their names instead, such as
+// Makefile. The whole table can be swapped while acmego runs:
+// formatting that is already under way keeps the formatter it looked
+// up, and later lookups see the new table.
+type registry struct {
+	mu    sync.RWMutex
+	fmts  map[string]Formatter // by extension
+	names map[string]Formatter // by base name or pattern
+	users *sync.WaitGroup      // the formats under way with the table
+}
+
+func newRegistry(fmts map[string]Formatter) *registry {
+	return &registry{fmts: fmts, names: newNameFmts(fmts), users: new(sync.WaitGroup)}
+}
+
+// hold returns the table of formatters in r as it is now, to look up
+// the formatter for a format in. Reload leaves the formatters of the
+// table open until release is called, once the format is done.
+func (r *registry) hold() (fmts *registry, release func()) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.users.Add(1)
+	return &registry{fmts: r.fmts, names: r.names, users: r.users}, r.users.Done
+}
+
+// lookup returns the formatter registered for ext.
+func (r *registry) lookup(ext string) (Formatter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fmter, ok := r.fmts[ext]
+	return fmter, ok
+}
+
+// lookupFile returns the formatter for file, and the name or extension
+// it is registered under. The base name of file is looked up first,
+// then the patterns among the names, matched with filepath.Match
+// against the base name in sorted order, such as "*.dockerfile", and
+// last the extension. The anyext formatter is not looked up.
+func (r *registry) lookupFile(file string) (Formatter, string, bool) {
+	base := filepath.Base(file)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if fmter, ok := r.names[base]; ok {
+		return fmter, base, true
+	}
+	var patterns []string
+	for pattern := range r.names {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return r.names[pattern], pattern, true
+		}
+	}
+	ext := fileExt(file)
+	fmter, ok := r.fmts[ext]
+	return fmter, ext, ok
+}
+
+// reload rebuilds the table of formatters and logs what changed. Once
+// the formats under way with the old table are done, it closes the
+// formatters that were removed or changed, as Close does; those left
+// unchanged may share what they hold, such as a language server, with
+// their replacements.
+func (r *registry) reload() {
+	forgetVersions()
+	fmts := newFmts()
+	names := newNameFmts(fmts)
+	r.mu.Lock()
+	old, users := keys(r.fmts, r.names), r.users
+	r.fmts, r.names, r.users = fmts, names, new(sync.WaitGroup)
+	r.mu.Unlock()
+	fmts = keys(fmts, names)
+
+	var added, removed, changed []string
+	for ext, fmter := range fmts {
+		if oldFmter, ok := old[ext]; !ok {
+			added = append(added, ext)
+		} else if !reflect.DeepEqual(fmter, oldFmter) {
+			changed = append(changed, ext)
+		}
+	}
+	for ext := range old {
+		if _, ok := fmts[ext]; !ok {
+			removed = append(removed, ext)
+		}
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		infof("reloaded formatters: no changes")
+		return
+	}
+	infof("reloaded formatters: added [%s] removed [%s] changed [%s]", list(added), list(removed), list(changed))
+	closeReplaced(old, users, append(removed, changed...))
+}
+
+// Close closes the formatters in r that hold resources of their own,
+// such as a process kept running between formats: those that are
+// io.Closers, each once, including those that the built-in chains,
+// fallbacks and limits are made of. The stateless formatters have
+// nothing to close. It returns the first error any of them returns.
+func (r *registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	seen := make(map[Formatter]bool)
+	for _, fmter := range keys(r.fmts, r.names) {
+		if err1 := closeFmter(fmter, seen); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// closeReplaced waits for the formats with users to be done and closes
+// the formatters of old under the names or extensions replaced, but for
+// those that are also under one of the others.
+func closeReplaced(old map[string]Formatter, users *sync.WaitGroup, replaced []string) {
+	users.Wait()
+	isReplaced := make(map[string]bool)
+	for _, ext := range replaced {
+		isReplaced[ext] = true
+	}
+	kept := make(map[Formatter]bool)
+	for ext, fmter := range old {
+		if !isReplaced[ext] {
+			kept[fmter] = true
+		}
+	}
+	for _, ext := range replaced {
+		if err := closeFmter(old[ext], kept); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// closeFmter closes fmter, or those it is made of, unless seen holds
+// them already, and adds them to seen.
+func closeFmter(fmter Formatter, seen map[Formatter]bool) error {
+	if seen[fmter] {
+		return nil
+	}
+	seen[fmter] = true
+	var parts []Formatter
+	switch f := fmter.(type) {
+	case *ChainFmt:
+		parts = f.fmts
+	case *FallbackFmt:
+		parts = []Formatter{f.primary, f.fallback}
+	case *LimitFmt:
+		parts = []Formatter{f.fmter}
+	case *EmbedFmt:
+		parts = []Formatter{f.fmter}
+	case io.Closer:
+		return f.Close()
+	}
+	var err error
+	for _, part := range parts {
+		if err1 := closeFmter(part, seen); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// keys merges the formatters by extension and by name into one map,
+// keyed by extension or by name, for reporting changes.
+func keys(fmts, names map[string]Formatter) map[string]Formatter {
+	all := make(map[string]Formatter, len(fmts)+len(names))
+	for ext, fmter := range fmts {
+		all[ext] = fmter
+	}
+	for name, fmter := range names {
+		all[name] = fmter
+	}
+	return all
+}
+
+func list(exts []string) string {
+	sort.Strings(exts)
+	return strings.Join(exts, " ")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookupFile(t *testing.T) {
+	mk, goFmt, docker := &MakeFmt{}, &fakeFmt{}, &fakeFmt{}
+	r := newRegistry(map[string]Formatter{"mk": mk, "go": goFmt})
+	r.names["*.dockerfile"] = docker
+	r.names["Dockerfile"] = docker
+	for _, test := range []struct {
+		file  string
+		fmter Formatter
+		key   string
+	}{
+		{"/src/Makefile", mk, "Makefile"},
+		{"/src/rules.mk", mk, "mk"},
+		{"/src/a.go", goFmt, "go"},
+		{"/src/Dockerfile", docker, "Dockerfile"},
+		{"/src/app.dockerfile", docker, "*.dockerfile"},
+		{"/src.go/go", nil, ""},
+	} {
+		fmter, key, ok := r.lookupFile(test.file)
+		if fmter != test.fmter || key != test.key || ok != (test.fmter != nil) {
+			t.Errorf("lookupFile(%q) = %T, %q, %v, want %T, %q", test.file, fmter, key, ok, test.fmter, test.key)
+		}
+	}
+}
+
+func TestLookupGoTest(t *testing.T) {
+	defer func(s string) { *goTestCmd = s }(*goTestCmd)
+	*goTestCmd = "sed s/p/P/"
+	goFmt := &fakeFmt{out: []byte("package p\n")}
+	r := newRegistry(map[string]Formatter{"go": goFmt})
+	if fmter, key, _ := r.lookupFile("/src/a.go"); fmter != goFmt || key != "go" {
+		t.Errorf("lookupFile(a.go) = %T, %q, want the Go formatter", fmter, key)
+	}
+	fmter, key, _ := r.lookupFile("/src/a_test.go")
+	if key != "*_test.go" {
+		t.Fatalf("lookupFile(a_test.go) = %T, %q, want *_test.go", fmter, key)
+	}
+	name := writeTemp(t, "package x\n")
+	defer os.RemoveAll(filepath.Dir(name))
+	out, err := fmter.format(name)
+	if err != nil || string(out) != "Package p\n" {
+		t.Errorf("format = %q, %v, want %q", out, err, "Package p\n")
+	}
+}
+
+// closeFmt is a fakeFmt that counts the times it is closed.
+type closeFmt struct {
+	fakeFmt
+	closed int
+}
+
+func (c *closeFmt) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestRegistryClose(t *testing.T) {
+	a, b := &closeFmt{}, &closeFmt{}
+	r := newRegistry(map[string]Formatter{
+		"a":  a,
+		"aa": a,
+		"b":  &LimitFmt{&ChainFmt{[]Formatter{&fakeFmt{}, b}}, make(chan struct{}, 1)},
+		"c":  &FallbackFmt{a, &MakeFmt{}},
+	})
+	r.names["B"] = b
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a.closed != 1 || b.closed != 1 {
+		t.Errorf("closed a %d times and b %d times, want once each", a.closed, b.closed)
+	}
+}
+
+func TestReloadCloses(t *testing.T) {
+	key := "acmego-test-server\x00/src"
+	server := &lspEntry{}
+	lspServers.Lock()
+	lspServers.m[key] = server
+	lspServers.Unlock()
+	a := &closeFmt{}
+	r := newRegistry(map[string]Formatter{
+		"go":         &LspFmt{args: []string{"acmego-test-server"}, timeout: time.Second},
+		"acmegotest": a,
+	})
+	_, release := r.hold()
+	done := make(chan bool)
+	go func() {
+		r.reload()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("reload returned with a format under way")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if a.closed != 0 {
+		t.Fatalf("closed a formatter in use %d times", a.closed)
+	}
+	release()
+	<-done
+	if a.closed != 1 {
+		t.Errorf("closed the removed formatter %d times, want once", a.closed)
+	}
+	lspServers.Lock()
+	_, ok := lspServers.m[key]
+	lspServers.Unlock()
+	if ok || !server.closed {
+		t.Errorf("reload left the server of the replaced LspFmt running")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// A version is the major, minor and patch numbers of a tool's version.
+type version [3]int
+
+// less reports whether v is older than w.
+func (v version) less(w version) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return v[i] < w[i]
+		}
+	}
+	return false
+}
+
+var versionRE = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseVersion returns the first version number in out, the output of
+// a tool's --version, which often comes after the name of the tool.
+func parseVersion(out []byte) (version, bool) {
+	m := versionRE.FindSubmatch(out)
+	if m == nil {
+		return version{}, false
+	}
+	var v version
+	for i := range v {
+		v[i], _ = strconv.Atoi(string(m[i+1]))
+	}
+	return v, true
+}
+
+// toolVersions caches the versions of the formatters' commands, so that
+// each runs with --version only once. It is cleared when the formatters
+// are reloaded, in case a tool has been upgraded since.
+var toolVersions = struct {
+	sync.Mutex
+	m map[string]*version // nil if the version is unknown
+}{m: make(map[string]*version)}
+
+// toolVersion returns the version cmd reports when run with --version,
+// if it can be found.
+func toolVersion(cmd string) (version, bool) {
+	toolVersions.Lock()
+	defer toolVersions.Unlock()
+	v, ok := toolVersions.m[cmd]
+	if !ok {
+		out, err := exec.Command(cmd, "--version").Output()
+		if ver, ok := parseVersion(out); err == nil && ok {
+			v = &ver
+			debugf("%s version %d.%d.%d", cmd, ver[0], ver[1], ver[2])
+		} else {
+			debugf("%s: version unknown", cmd)
+		}
+		toolVersions.m[cmd] = v
+	}
+	if v == nil {
+		return version{}, false
+	}
+	return *v, true
+}
+
+// forgetVersions clears the cache of tool versions.
+func forgetVersions() {
+	toolVersions.Lock()
+	toolVersions.m = make(map[string]*version)
+	toolVersions.Unlock()
+}
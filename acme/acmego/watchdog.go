@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A watchdog warns when formatting a file has taken longer than limit,
+// as when a formatter hangs with no timeout or despite it. Saves then
+// go unformatted with no other sign of why. The warning tells how many
+// formats are waiting meanwhile for their turn.
+type watchdog struct {
+	limit time.Duration
+	logf  func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	busy    map[string]*stall // by the file being formatted
+	pending int               // the formats given but not yet started
+}
+
+// A stall is a format under way, since when, and whether it has been
+// reported as taking too long.
+type stall struct {
+	since  time.Time
+	warned bool
+}
+
+// newWatchdog returns a watchdog with the given limit, watching the
+// formats in the background unless limit is 0.
+func newWatchdog(limit time.Duration) *watchdog {
+	w := &watchdog{limit: limit, logf: log.Printf, busy: make(map[string]*stall)}
+	if limit > 0 {
+		go func() {
+			for now := range time.Tick(limit / 4) {
+				w.check(now)
+			}
+		}()
+	}
+	return w
+}
+
+// queued records that a format was given to the workers.
+func (w *watchdog) queued() {
+	w.mu.Lock()
+	w.pending++
+	w.mu.Unlock()
+}
+
+// taken records that a worker started on a format.
+func (w *watchdog) taken() {
+	w.mu.Lock()
+	w.pending--
+	w.mu.Unlock()
+}
+
+// begin records that formatting file starts.
+func (w *watchdog) begin(file string) {
+	w.mu.Lock()
+	w.busy[file] = &stall{since: time.Now()}
+	w.mu.Unlock()
+}
+
+// end records that formatting file is done, reporting how long it took
+// if it was stalled.
+func (w *watchdog) end(file string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s := w.busy[file]; s != nil && s.warned {
+		w.logf("acmego: done with %s after %v", file, time.Since(s.since).Round(time.Millisecond))
+	}
+	delete(w.busy, file)
+}
+
+// check warns, once a stall, of the files that have been formatted for
+// longer than the limit at time now.
+func (w *watchdog) check(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var files []string
+	for file := range w.busy {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		s := w.busy[file]
+		if s.warned || now.Sub(s.since) <= w.limit {
+			continue
+		}
+		s.warned = true
+		w.logf("acmego: stalled formatting %s for %v, %d formats waiting", file, now.Sub(s.since).Round(time.Second), w.pending)
+	}
+}
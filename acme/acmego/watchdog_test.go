@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	w := newWatchdog(0)
+	w.limit = time.Minute
+	var logged []string
+	w.logf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	w.check(time.Now().Add(time.Hour))
+	if len(logged) > 0 {
+		t.Fatalf("idle: logged %q", logged)
+	}
+
+	w.queued()
+	w.queued()
+	w.taken()
+	w.begin("/a/x.go")
+	since := w.busy["/a/x.go"].since
+	w.begin("/a/y.go")
+	w.busy["/a/y.go"].since = since.Add(90 * time.Second)
+	w.check(since.Add(30 * time.Second))
+	if len(logged) > 0 {
+		t.Fatalf("formatting within the limit: logged %q", logged)
+	}
+	w.check(since.Add(2 * time.Minute))
+	w.check(since.Add(3 * time.Minute))
+	want := []string{
+		"acmego: stalled formatting /a/x.go for 2m0s, 1 formats waiting",
+		"acmego: stalled formatting /a/y.go for 1m30s, 1 formats waiting",
+	}
+	if len(logged) != 2 || logged[0] != want[0] || logged[1] != want[1] {
+		t.Fatalf("stalled formats: logged %q, want %q", logged, want)
+	}
+	w.end("/a/x.go")
+	if len(logged) != 3 || !strings.HasPrefix(logged[2], "acmego: done with /a/x.go after ") {
+		t.Fatalf("end of stall: logged %q", logged)
+	}
+
+	w.begin("/a/z.go")
+	w.end("/a/z.go")
+	if len(logged) != 3 {
+		t.Errorf("formatting with no stall: logged %q", logged[3:])
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Configuration files that formatters look for, from which the
+// directory to run them in is found with resolveWorkdir.
+var (
+	goMarkers     = []string{"go.mod"}
+	pyMarkers     = []string{".style.yapf", "setup.cfg", "pyproject.toml"}
+	rustMarkers   = []string{"rustfmt.toml", ".rustfmt.toml", "Cargo.toml"}
+	elmMarkers    = []string{"elm.json"}
+	luaMarkers    = []string{"stylua.toml", ".stylua.toml"}
+	ocamlMarkers  = []string{".ocamlformat"}
+	dartMarkers   = []string{"analysis_options.yaml", "pubspec.yaml"}
+	swiftMarkers  = []string{".swift-format"}
+	rubyMarkers   = []string{".rubocop.yml", ".standard.yml", "Gemfile"}
+	phpMarkers    = []string{".php-cs-fixer.php", ".php-cs-fixer.dist.php", "phpcs.xml", ".phpcs.xml", "phpcs.xml.dist"}
+	kotlinMarkers = []string{".editorconfig"}
+	dockerMarkers = []string{".hadolint.yaml", ".hadolint.yml"}
+	jsMarkers     = []string{".prettierrc", ".prettierrc.json", ".prettierrc.yaml", ".prettierrc.yml", ".prettierrc.js", "prettier.config.js", "package.json"}
+)
+
+// resolveWorkdir returns the directory to run a formatter for file in:
+// the nearest directory, from that of file up, that holds one of the
+// formatter's configuration files, named by markers. If there is none,
+// or no markers are given, it is the directory of file.
+func resolveWorkdir(file string, markers []string) string {
+	if config := findUp(filepath.Dir(file), markers); config != "" {
+		return filepath.Dir(config)
+	}
+	return filepath.Dir(file)
+}
+
+// findUp returns the file named by the first of names found in dir
+// or, failing that, in the nearest of its parents, or "" if there is
+// none.
+func findUp(dir string, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range names {
+			name = filepath.Join(dir, name)
+			if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+				return name
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
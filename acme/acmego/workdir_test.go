@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveWorkdir(t *testing.T) {
+	root, err := ioutil.TempDir("", "acmego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(filepath.Join(sub, "stylua.toml"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "stylua.toml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "x.lua")
+	tests := []struct {
+		markers []string
+		want    string
+	}{
+		{nil, sub},
+		{[]string{"none"}, sub},
+		// The directory named stylua.toml in sub is passed over.
+		{luaMarkers, root},
+	}
+	for _, test := range tests {
+		if dir := resolveWorkdir(file, test.markers); dir != test.want {
+			t.Errorf("resolveWorkdir(%q, %q) = %q, want %q", file, test.markers, dir, test.want)
+		}
+	}
+}
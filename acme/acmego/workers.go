@@ -0,0 +1,77 @@
+package main
+
+// A workerPool runs the formats of the windows, at most cap(sem) at
+// once, so that a slow formatter on one file does not hold up the
+// others. The jobs of one window run one at a time, in the order they
+// were given, so that two formats never write to a window's body at
+// once, nor an older one after a newer.
+type workerPool struct {
+	sem  chan struct{}
+	dog  *watchdog
+	jobs chan poolJob
+	// queues holds the jobs waiting behind the one running for each
+	// window; a window has an entry for as long as it has a job
+	// running. It is only touched by the goroutine of loop.
+	queues map[int][]func()
+	done   chan int
+}
+
+type poolJob struct {
+	id  int
+	job func()
+}
+
+// newWorkerPool returns a pool running at most n jobs at once, telling
+// dog how many wait for their turn.
+func newWorkerPool(n int, dog *watchdog) *workerPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &workerPool{
+		sem:    make(chan struct{}, n),
+		dog:    dog,
+		jobs:   make(chan poolJob),
+		queues: make(map[int][]func()),
+		done:   make(chan int),
+	}
+	go p.loop()
+	return p
+}
+
+// run runs job, a format of window id, after the jobs given before for
+// that window.
+func (p *workerPool) run(id int, job func()) {
+	p.dog.queued()
+	p.jobs <- poolJob{id, job}
+}
+
+func (p *workerPool) loop() {
+	for {
+		select {
+		case j := <-p.jobs:
+			if q, busy := p.queues[j.id]; busy {
+				p.queues[j.id] = append(q, j.job)
+				continue
+			}
+			p.queues[j.id] = nil
+			go p.work(j.id, j.job)
+		case id := <-p.done:
+			q := p.queues[id]
+			if len(q) == 0 {
+				delete(p.queues, id)
+				continue
+			}
+			p.queues[id] = q[1:]
+			go p.work(id, q[0])
+		}
+	}
+}
+
+// work runs job of window id once there is room for it.
+func (p *workerPool) work(id int, job func()) {
+	p.sem <- struct{}{}
+	p.dog.taken()
+	job()
+	<-p.sem
+	p.done <- id
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	const (
+		size    = 3
+		windows = 5
+		events  = 20
+	)
+	p := newWorkerPool(size, newWatchdog(0))
+	var (
+		mu      sync.Mutex
+		order   = make(map[int][]int) // the events run, by window
+		running = make(map[int]int)   // the jobs running, by window
+		active  int
+		most    int
+		wg      sync.WaitGroup
+	)
+	for n := 0; n < events; n++ {
+		for id := 1; id <= windows; id++ {
+			id, n := id, n
+			wg.Add(1)
+			p.run(id, func() {
+				defer wg.Done()
+				mu.Lock()
+				if running[id]++; running[id] > 1 {
+					t.Errorf("window %d: two jobs at once", id)
+				}
+				if active++; active > most {
+					most = active
+				}
+				order[id] = append(order[id], n)
+				mu.Unlock()
+				time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+				mu.Lock()
+				running[id]--
+				active--
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+	var want []int
+	for n := 0; n < events; n++ {
+		want = append(want, n)
+	}
+	for id := 1; id <= windows; id++ {
+		if !reflect.DeepEqual(order[id], want) {
+			t.Errorf("window %d: events ran in the order %v", id, order[id])
+		}
+	}
+	if most > size {
+		t.Errorf("%d jobs ran at once, want at most %d", most, size)
+	}
+	if most < 2 {
+		t.Errorf("at most %d job ran at once, want jobs of different windows to run together", most)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// XmlFmt indents XML by indent blanks a level, or a tab if indent is
+// not positive. Elements that hold only other elements, comments and
+// processing instructions get each of them on a line of its own; those
+// that hold text, CDATA sections or xml:space="preserve" are kept as
+// they are, as their white space may matter. Tags, attributes in their
+// order, namespace prefixes, entities and CDATA sections are copied
+// from the file, not reencoded. The formatter is implemented
+// in-process; it aborts with an error on malformed XML.
+type XmlFmt struct {
+	indent int
+}
+
+func (x *XmlFmt) format(file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	new, err := x.indentXML(src)
+	if err != nil {
+		fmtError(file, "xmlfmt", err, nil)
+	}
+	return new, err
+}
+
+// An xmlNode is an element, with the text of its start and end tags,
+// or some other piece of XML, with its text in start.
+type xmlNode struct {
+	start, end []byte
+	elem       bool
+	chars      bool // text, or a CDATA section
+	preserve   bool // an element with xml:space="preserve"
+	kids       []*xmlNode
+}
+
+// isSpace reports whether n is text that is only white space.
+func (n *xmlNode) isSpace() bool {
+	return n.chars && len(bytes.TrimSpace(n.start)) == 0
+}
+
+// text reports whether n is text other than white space.
+func (n *xmlNode) text() bool {
+	return n.chars && !n.isSpace()
+}
+
+func (x *XmlFmt) indentXML(src []byte) ([]byte, error) {
+	root, err := parseXML(src)
+	if err != nil {
+		return nil, err
+	}
+	unit := "\t"
+	if x.indent > 0 {
+		unit = strings.Repeat(" ", x.indent)
+	}
+	var buf bytes.Buffer
+	for _, n := range root.kids {
+		if n.isSpace() {
+			continue
+		}
+		writeXML(&buf, n, "", unit)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// parseXML returns a node holding the pieces of the document src.
+func parseXML(src []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(src))
+	root := new(xmlNode)
+	stack := []*xmlNode{root}
+	var names []xml.Name
+	elems := 0
+	prev := int64(0)
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		off := d.InputOffset()
+		raw := src[prev:off]
+		prev = off
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 1 {
+				if elems++; elems > 1 {
+					return nil, xmlError(src, off, "more than one root element")
+				}
+			}
+			n := &xmlNode{start: raw, elem: true}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xml" && a.Name.Local == "space" {
+					n.preserve = a.Value == "preserve"
+				}
+			}
+			top.kids = append(top.kids, n)
+			stack = append(stack, n)
+			names = append(names, t.Name)
+		case xml.EndElement:
+			if len(names) == 0 {
+				return nil, xmlError(src, off, "unexpected end element </%s>", xmlName(t.Name))
+			}
+			if name := names[len(names)-1]; name != t.Name {
+				return nil, xmlError(src, off, "element <%s> closed by </%s>", xmlName(name), xmlName(t.Name))
+			}
+			// A self-closing tag ends with no text of its own.
+			top.end = raw
+			stack, names = stack[:len(stack)-1], names[:len(names)-1]
+		default:
+			_, chars := t.(xml.CharData)
+			n := &xmlNode{start: raw, chars: chars}
+			if len(stack) == 1 && n.text() {
+				return nil, xmlError(src, off, "text outside the root element")
+			}
+			top.kids = append(top.kids, n)
+		}
+	}
+	if len(names) > 0 {
+		return nil, xmlError(src, int64(len(src)), "element <%s> is not closed", xmlName(names[len(names)-1]))
+	}
+	if elems == 0 {
+		return nil, xmlError(src, int64(len(src)), "no root element")
+	}
+	return root, nil
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// xmlError returns an error at offset off in src.
+func xmlError(src []byte, off int64, format string, args ...interface{}) error {
+	line := 1 + bytes.Count(src[:off], []byte("\n"))
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// writeXML writes n, which starts indent into a line, to buf.
+func writeXML(buf *bytes.Buffer, n *xmlNode, indent, unit string) {
+	buf.Write(n.start)
+	if !n.elem {
+		return
+	}
+	if verbatimXML(n) {
+		for _, k := range n.kids {
+			writeRawXML(buf, k)
+		}
+		buf.Write(n.end)
+		return
+	}
+	for _, k := range n.kids {
+		if k.isSpace() {
+			continue
+		}
+		buf.WriteString("\n" + indent + unit)
+		writeXML(buf, k, indent+unit, unit)
+	}
+	buf.WriteString("\n" + indent)
+	buf.Write(n.end)
+}
+
+// verbatimXML reports whether the content of element n is to be kept as
+// it is: it is empty, holds text or preserves its white space.
+func verbatimXML(n *xmlNode) bool {
+	if n.preserve {
+		return true
+	}
+	only := true // only white space
+	for _, k := range n.kids {
+		if k.text() {
+			return true
+		}
+		if !k.isSpace() {
+			only = false
+		}
+	}
+	return only
+}
+
+// writeRawXML writes n as it was in the file.
+func writeRawXML(buf *bytes.Buffer, n *xmlNode) {
+	buf.Write(n.start)
+	for _, k := range n.kids {
+		writeRawXML(buf, k)
+	}
+	buf.Write(n.end)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+var xmlTests = []struct {
+	name     string
+	src, out string
+}{
+	{
+		name: "nested elements",
+		src:  "<?xml version=\"1.0\"?>\n<a><b z=\"1\" y='2'><c/></b>\n      <d>text</d></a>",
+		out:  "<?xml version=\"1.0\"?>\n<a>\n  <b z=\"1\" y='2'>\n    <c/>\n  </b>\n  <d>text</d>\n</a>\n",
+	},
+	{
+		name: "comments and CDATA",
+		src:  "<a>\n<!-- note -->\n<s><![CDATA[ x < y ]]></s><t>a &amp; <i>b</i></t></a>\n",
+		out:  "<a>\n  <!-- note -->\n  <s><![CDATA[ x < y ]]></s>\n  <t>a &amp; <i>b</i></t>\n</a>\n",
+	},
+	{
+		name: "namespaces",
+		src:  "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:x=\"urn:x\"><x:g><path d=\"M0 0\"/></x:g></svg>",
+		out:  "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:x=\"urn:x\">\n  <x:g>\n    <path d=\"M0 0\"/>\n  </x:g>\n</svg>\n",
+	},
+	{
+		name: "preserved space",
+		src:  "<a><pre xml:space=\"preserve\">\n <b/>\n</pre><e>  </e></a>",
+		out:  "<a>\n  <pre xml:space=\"preserve\">\n <b/>\n</pre>\n  <e>  </e>\n</a>\n",
+	},
+}
+
+func TestIndentXML(t *testing.T) {
+	x := &XmlFmt{indent: 2}
+	for _, test := range xmlTests {
+		out, err := x.indentXML([]byte(test.src))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(out) != test.out {
+			t.Errorf("%s:\n%q\nwant:\n%q", test.name, out, test.out)
+			continue
+		}
+		if again, _ := x.indentXML(out); string(again) != string(out) {
+			t.Errorf("%s: not idempotent:\n%q", test.name, again)
+		}
+	}
+}
+
+func TestIndentXMLError(t *testing.T) {
+	x := &XmlFmt{}
+	for _, src := range []string{
+		"<a><b></a>",
+		"<a>",
+		"</a>",
+		"<a/><b/>",
+		"text<a/>",
+		"",
+		"<a x=1/>",
+	} {
+		if out, err := x.indentXML([]byte(src)); err == nil {
+			t.Errorf("indentXML(%q) = %q, want error", src, out)
+		}
+	}
+}
@@ -37,3 +37,21 @@ func TestBenchmarkString(t *testing.T) {
 	end := time.Now()
 	fmt.Println("time for one char:", end.Sub(start)/nchars)
 }
+
+func BenchmarkStringWidth(b *testing.B) {
+	testOnce.Do(testInit)
+	f := testDisplay.DefaultFont
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.StringWidth(aHundredChars)
+	}
+}
+
+func BenchmarkMeasurer(b *testing.B) {
+	testOnce.Do(testInit)
+	m := testDisplay.DefaultFont.NewMeasurer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Measure(aHundredChars)
+	}
+}
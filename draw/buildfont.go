@@ -121,6 +121,7 @@ func (f *Font) Free() {
 	}
 
 	f.free()
+	f.gen++
 }
 
 func (f *Font) free() {
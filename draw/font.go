@@ -19,6 +19,41 @@ type Font struct {
 	Ascent  int    // top of image to baseline
 	Scale   int    // pixel scaling
 
+	// EastAsianWidth makes the width functions measure runes that are
+	// wide by Unicode East Asian Width rules (CJK ideographs, Hangul,
+	// fullwidth forms and the like) as exactly two cells, a cell being
+	// the width of '0'. It is meant for monospace fonts whose wide
+	// glyphs have unreliable metrics and should be left unset for
+	// proportional fonts. It does not affect how strings are drawn.
+	EastAsianWidth bool
+
+	// Replacement is the rune whose width the width functions use for
+	// runes that the font, and the display's default font, cannot show,
+	// so that measurement carries on past them. It is also measured in
+	// place of each byte of invalid UTF-8 and of each invalid rune, such
+	// as a surrogate half. If zero, the Unicode replacement character
+	// U+FFFD is used.
+	Replacement rune
+
+	// DirectionalMarks makes the width functions give no width to the
+	// invisible bidirectional formatting characters, such as LRM
+	// (U+200E) and RLM (U+200F), whatever glyph the font has for them.
+	DirectionalMarks bool
+
+	// Widths, if not nil, gives the widths in pixels that the width
+	// functions use for the runes it lists instead of those the font
+	// has, such as one fixed cell for all box-drawing characters. It
+	// takes precedence over EastAsianWidth and DirectionalMarks and
+	// does not affect how strings are drawn.
+	Widths map[rune]int
+
+	// Reorder, if set, is called by StringWidth, BytesWidth, RunesWidth
+	// and StringWidthTabStops with the runes of the text in logical
+	// order and returns them in the visual order in which they are
+	// measured. It is a hook for a bidirectional layout; it may reorder
+	// r in place. Neither option affects how strings are drawn.
+	Reorder func(r []rune) []rune
+
 	namespec   string
 	mu         sync.Mutex // only used if Display == nil
 	width      int        // widest so far; used in caching only
@@ -29,6 +64,13 @@ type Font struct {
 	sub        []*cachefont // as read from file
 	cacheimage *Image
 
+	gen        uint32 // generation; see Generation
+	haveascii  bool   // avgwidth, maxwidth and spacewidth are set
+	asciigen   uint32 // generation in which they were computed
+	avgwidth   int    // mean width of printable ASCII
+	maxwidth   int    // max width of printable ASCII
+	spacewidth int    // width of the space
+
 	// doubly linked list of fonts known to display
 	ondisplaylist bool
 	next          *Font
@@ -39,6 +81,28 @@ type Font struct {
 	hidpi *Font
 }
 
+// Generation returns the font's generation, a number that changes
+// whenever the glyphs the font measures and draws may have changed.
+// That happens when the font is swapped for its high- or low-DPI
+// version, as Attach does when the display resizes or moves to a
+// screen with another DPI, and when the font is freed. Setting the
+// measurement options such as EastAsianWidth does not change it.
+// Callers that remember widths should include the generation in their
+// cache keys, or empty their caches when it changes.
+func (f *Font) Generation() uint32 {
+	f.lock()
+	defer f.unlock()
+	return f.gen
+}
+
+// replacement returns the rune measured in place of runes f cannot show.
+func (f *Font) replacement() rune {
+	if f.Replacement == 0 {
+		return utf8.RuneError
+	}
+	return f.Replacement
+}
+
 func (f *Font) lock() {
 	if f.Display != nil {
 		f.Display.mu.Lock()
@@ -64,11 +128,13 @@ type cachefont struct {
 }
 
 type cacheinfo struct {
-	x     uint16
-	width uint8
-	left  int8
-	value rune
-	age   uint32
+	x      uint16
+	width  uint8  // advance
+	left   int8   // offset of glyph image from the origin
+	img    uint8  // width of glyph image
+	height uint16 // height of the subfont the glyph comes from
+	value  rune
+	age    uint32
 }
 
 type cachesubf struct {
@@ -171,6 +237,9 @@ Loop:
 		ld, subfontname = loadchar(f, r, c, h, i > 0)
 		if ld <= 0 {
 			if ld == 0 {
+				if in.noteSkips {
+					in.skip = append(in.skip, skipped{i, r})
+				}
 				continue Loop
 			}
 			break Loop
@@ -368,8 +437,10 @@ Found2:
 	top = int(fi[0].Top) + (f.Ascent - subf.f.Ascent)
 	bottom = int(fi[0].Bottom) + (f.Ascent - subf.f.Ascent)
 	c.width = fi[0].Width
+	c.img = uint8(wid)
 	c.x = uint16(h * int(f.width))
 	c.left = fi[0].Left
+	c.height = uint16(subf.f.Height)
 	if f.Display == nil {
 		return 1, ""
 	}
@@ -444,13 +515,24 @@ Return:
 
 // An input can read a rune at a time from a string, []byte, or []rune.
 type input struct {
-	mode int
-	s    string
-	b    []byte
-	r    []rune
-	size int
-	ch   rune
-	done bool
+	mode      int
+	s         string
+	b         []byte
+	r         []rune
+	size      int
+	ch        rune
+	done      bool
+	skip      []skipped // runes cachechars found no glyph for, with noteSkips
+	noteSkips bool      // whether cachechars records in skip the runes it leaves out
+	bad       rune      // if not 0, read in place of invalid UTF-8 and runes
+	subst     *[]rune   // if not nil, gets the invalid runes read as bad
+}
+
+// A skipped rune r has no glyph; it came after the first at characters
+// put in the cache by a call of cachechars.
+type skipped struct {
+	at int
+	r  rune
 }
 
 func (in *input) init(s string, b []byte, r []rune) {
@@ -478,6 +560,9 @@ func (in *input) next() {
 			return
 		}
 		in.ch, in.size = utf8.DecodeRuneInString(in.s)
+		if in.ch == utf8.RuneError && in.size == 1 && in.bad != 0 {
+			in.replace(in.ch)
+		}
 	case 1:
 		in.b = in.b[in.size:]
 		if len(in.b) == 0 {
@@ -485,6 +570,9 @@ func (in *input) next() {
 			return
 		}
 		in.ch, in.size = utf8.DecodeRune(in.b)
+		if in.ch == utf8.RuneError && in.size == 1 && in.bad != 0 {
+			in.replace(in.ch)
+		}
 	case 2:
 		in.r = in.r[in.size:]
 		if len(in.r) == 0 {
@@ -493,6 +581,17 @@ func (in *input) next() {
 		}
 		in.ch = in.r[0]
 		in.size = 1
+		if !utf8.ValidRune(in.ch) && in.bad != 0 {
+			in.replace(in.ch)
+		}
 	}
 	//println("next is ", in.ch, in.done)
 }
+
+// replace reads in.bad in place of the invalid rune r.
+func (in *input) replace(r rune) {
+	if in.subst != nil {
+		*in.subst = append(*in.subst, r)
+	}
+	in.ch = in.bad
+}
@@ -0,0 +1,135 @@
+package draw
+
+import "unicode"
+
+// A clusterer finds the boundaries of grapheme clusters, the
+// user-perceived characters of Unicode text, in a sequence of runes.
+// It follows the rules of Unicode Standard Annex #29 that matter for
+// text on screen: combining marks and other extenders, emoji modifier
+// and ZWJ sequences, flag pairs of regional indicators, Hangul
+// syllables and CR LF. The prepend and Indic conjunct rules are not
+// implemented.
+type clusterer struct {
+	started bool
+	prev    rune
+	ri      int  // regional indicators at the end of the cluster
+	pict    bool // cluster ends in a pictograph and extenders
+}
+
+// next reports whether r starts a new cluster after the runes seen so far.
+func (c *clusterer) next(r rune) bool {
+	brk := !c.started || c.breaks(c.prev, r)
+	if brk {
+		c.ri = 0
+		c.pict = false
+	}
+	switch {
+	case isRegional(r):
+		c.ri++
+	case isPictographic(r):
+		c.pict = true
+	case !isExtend(r) && r != zwj:
+		c.pict = false
+	}
+	c.started = true
+	c.prev = r
+	return brk
+}
+
+const zwj = 0x200d // zero width joiner
+
+// breaks reports whether there is a cluster boundary between prev and r.
+func (c *clusterer) breaks(prev, r rune) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case isControl(prev) || isControl(r):
+		return true
+	case hangulJoins(prev, r):
+		return false
+	case isExtend(r) || r == zwj || unicode.Is(unicode.Mc, r):
+		return false
+	case prev == zwj && c.pict && isPictographic(r):
+		return false
+	case isRegional(prev) && isRegional(r):
+		// Regional indicators pair up into flags.
+		return c.ri%2 == 0
+	}
+	return true
+}
+
+func isControl(r rune) bool {
+	return r == '\r' || r == '\n' || unicode.Is(unicode.Cc, r) || r == 0x2028 || r == 0x2029
+}
+
+// isExtend reports whether r extends the cluster before it.
+func isExtend(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) ||
+		r == 0x200c || // zero width non-joiner
+		0x1f3fb <= r && r <= 0x1f3ff || // emoji skin tone modifiers
+		0xe0020 <= r && r <= 0xe007f // tags
+}
+
+func isRegional(r rune) bool {
+	return 0x1f1e6 <= r && r <= 0x1f1ff
+}
+
+// isPictographic approximates the Extended_Pictographic property
+// with the blocks that hold emoji.
+func isPictographic(r rune) bool {
+	switch {
+	case r == 0xa9, r == 0xae, r == 0x203c, r == 0x2049, r == 0x2122, r == 0x2139:
+		return true
+	case 0x2194 <= r && r <= 0x21aa, 0x2300 <= r && r <= 0x23ff:
+		return true
+	case 0x25aa <= r && r <= 0x27bf, 0x2934 <= r && r <= 0x2935, 0x2b05 <= r && r <= 0x2b55:
+		return true
+	case r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+		return true
+	case 0x1f000 <= r && r <= 0x1faff && !isRegional(r) && !(0x1f3fb <= r && r <= 0x1f3ff):
+		return true
+	}
+	return false
+}
+
+// Hangul syllable types.
+const (
+	hangulNone = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulType(r rune) int {
+	switch {
+	case 0x1100 <= r && r <= 0x115f, 0xa960 <= r && r <= 0xa97c:
+		return hangulL
+	case 0x1160 <= r && r <= 0x11a7, 0xd7b0 <= r && r <= 0xd7c6:
+		return hangulV
+	case 0x11a8 <= r && r <= 0x11ff, 0xd7cb <= r && r <= 0xd7fb:
+		return hangulT
+	case 0xac00 <= r && r <= 0xd7a3:
+		if (r-0xac00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// hangulJoins reports whether the jamo or syllables prev and r are
+// parts of the same Hangul syllable.
+func hangulJoins(prev, r rune) bool {
+	p, t := hangulType(prev), hangulType(r)
+	switch p {
+	case hangulL:
+		return t == hangulL || t == hangulV || t == hangulLV || t == hangulLVT
+	case hangulLV, hangulV:
+		return t == hangulV || t == hangulT
+	case hangulLVT, hangulT:
+		return t == hangulT
+	}
+	return false
+}
@@ -94,6 +94,8 @@ func swapfont(targ *Font, oldp, newp **Font) {
 
 	*oldp = new
 	*newp = old
+	old.gen++
+	new.gen++
 }
 
 func copyfont(dst, src *Font) {
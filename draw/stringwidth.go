@@ -1,46 +1,185 @@
 package draw
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"os"
+	"sync"
+	"unicode/utf8"
 )
 
+// An advancefn returns the width to use for the cached character c,
+// which starts x pixels into the text.
+type advancefn func(c *cacheinfo, x int) int
+
 func stringnwidth(f *Font, s string, b []byte, r []rune) int {
-	const Max = 64
-	cbuf := make([]uint16, Max)
-	var in input
+	s, b, r = visual(f, s, b, r)
+	return measure(f, s, b, r, runeadvance(f))
+}
+
+// visual returns the text to measure in visual order, as given by
+// f.Reorder. Without a Reorder function the text is returned unchanged.
+func visual(f *Font, s string, b []byte, r []rune) (string, []byte, []rune) {
+	if f.Reorder == nil {
+		return s, b, r
+	}
+	switch {
+	case r != nil:
+		r = append([]rune(nil), r...)
+	case b != nil:
+		r = []rune(string(b))
+	default:
+		r = []rune(s)
+	}
+	return "", nil, f.Reorder(r)
+}
+
+// runeadvance returns the advancefn implementing the measurement
+// options set in f, or nil if the font's widths are used as they are.
+func runeadvance(f *Font) advancefn {
+	if !f.EastAsianWidth && !f.DirectionalMarks && f.Widths == nil {
+		return nil
+	}
+	cell := 0
+	if f.EastAsianWidth {
+		cell = measure(f, "0", nil, nil, nil)
+	}
+	return func(c *cacheinfo, x int) int {
+		if w, ok := f.Widths[c.value]; ok {
+			return w
+		}
+		switch {
+		case f.DirectionalMarks && isBidiControl(c.value):
+			return 0
+		case f.EastAsianWidth && isWide(c.value):
+			return 2 * cell
+		}
+		return int(c.width)
+	}
+}
+
+// isBidiControl reports whether r is one of the invisible characters
+// that control the direction of bidirectional text: the LRM, RLM and
+// ALM marks and the explicit embedding, override and isolate controls.
+func isBidiControl(r rune) bool {
+	switch {
+	case r == 0x061c, r == 0x200e, r == 0x200f:
+		return true
+	case 0x202a <= r && r <= 0x202e, 0x2066 <= r && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// measureMax is the number of characters measured per call of cachechars.
+const measureMax = 64
+
+// A Measurer measures text in a font the way StringWidth does, but keeps
+// its buffers from one measurement to the next, so that measuring many
+// strings in a row does not create garbage. A Measurer must not be used
+// by multiple goroutines at once.
+type Measurer struct {
+	f      *Font
+	cbuf   [measureMax]uint16
+	in     input
+	norepl bool    // measuring the replacement rune itself
+	subst  *[]rune // if not nil, gets the runes f cannot show
+}
+
+// NewMeasurer returns a Measurer for the font.
+func (f *Font) NewMeasurer() *Measurer {
+	return &Measurer{f: f}
+}
+
+// Measure returns the number of horizontal pixels that would be occupied
+// by the string if it were drawn using the font.
+func (m *Measurer) Measure(s string) int {
+	m.f.lock()
+	defer m.f.unlock()
+	return m.measure(s, nil, nil, runeadvance(m.f))
+}
+
+// MeasureBytes returns the number of horizontal pixels that would be
+// occupied by the byte slice if it were drawn using the font.
+func (m *Measurer) MeasureBytes(b []byte) int {
+	m.f.lock()
+	defer m.f.unlock()
+	return m.measure("", b, nil, runeadvance(m.f))
+}
+
+// MeasureRunes returns the number of horizontal pixels that would be
+// occupied by the rune slice if it were drawn using the font.
+func (m *Measurer) MeasureRunes(r []rune) int {
+	m.f.lock()
+	defer m.f.unlock()
+	return m.measure("", nil, r, runeadvance(m.f))
+}
+
+var measurers = sync.Pool{
+	New: func() interface{} { return new(Measurer) },
+}
+
+// measure returns the width of the text in f, using a pooled Measurer.
+// If adv is not nil, it is consulted for the width of every rune.
+func measure(f *Font, s string, b []byte, r []rune, adv advancefn) int {
+	m := measurers.Get().(*Measurer)
+	m.f = f
+	wid := m.measure(s, b, r, adv)
+	m.f = nil
+	measurers.Put(m)
+	return wid
+}
+
+func (m *Measurer) measure(s string, b []byte, r []rune, adv advancefn) int {
+	f := m.f
+	cbuf := m.cbuf[:]
+	in := &m.in
+	*in = input{skip: in.skip[:0], noteSkips: true, bad: f.replacement(), subst: m.subst}
 	in.init(s, b, r)
+	repl := -1 // width of the replacement rune, once needed
 	twid := 0
+	var failed map[string]bool // subfonts of m.f that would not load
 	for !in.done {
-		max := Max
+		// Each run of runes is measured in the font that can show
+		// it: m.f as far as it can, and the display's default font
+		// only for the runes whose subfonts in m.f fail to load,
+		// one at a time, so that m.f takes over again after them.
+		f = m.f
+		max := measureMax
 		n := 0
 		var sf *Subfont
 		var l, wid int
 		var subfontname string
 		for {
-			if l, wid, subfontname = cachechars(f, &in, cbuf, max); l > 0 {
+			if l, wid, subfontname = cachechars(f, in, cbuf, max); l > 0 || len(in.skip) > 0 {
 				break
 			}
 			if n++; n > 10 {
-				r := in.ch
-				name := f.Name
-				if name == "" {
-					name = "unnamed font"
-				}
-				sf.free()
-				fmt.Fprintf(os.Stderr, "stringwidth: bad character set for rune %U in %s\n", r, name)
-				return twid
+				break
 			}
 			if subfontname != "" {
 				sf.free()
+				sf = nil
 				var err error
-				sf, err = getsubfont(f.Display, subfontname)
+				if f == m.f && failed[subfontname] {
+					err = errSubfontFailed
+				} else {
+					sf, err = getsubfont(f.Display, subfontname)
+				}
 				if err != nil {
 					if f.Display != nil && f != f.Display.DefaultFont {
+						if f == m.f {
+							if failed == nil {
+								failed = make(map[string]bool)
+							}
+							failed[subfontname] = true
+						}
 						f = f.Display.DefaultFont
+						max = 1
 						continue
 					}
+					n = 11
 					break
 				}
 				/*
@@ -49,6 +188,60 @@ func stringnwidth(f *Font, s string, b []byte, r []rune) int {
 				 */
 			}
 		}
+		if n > 10 {
+			// No font can show in.ch. Measure it as the
+			// replacement rune, unless that is what failed.
+			if m.norepl {
+				name := f.Name
+				if name == "" {
+					name = "unnamed font"
+				}
+				sf.free()
+				fmt.Fprintf(os.Stderr, "stringwidth: bad character set for rune %U in %s\n", in.ch, name)
+				return twid
+			}
+			in.skip = append(in.skip, skipped{0, in.ch})
+			in.next()
+		}
+		if m.subst != nil {
+			for _, sk := range in.skip {
+				*m.subst = append(*m.subst, sk.r)
+			}
+			if f != m.f {
+				for i := 0; i < l; i++ {
+					*m.subst = append(*m.subst, f.cache[cbuf[i]].value)
+				}
+			}
+		}
+		if len(in.skip) > 0 && repl < 0 {
+			repl = 0
+			if !m.norepl {
+				repl = replwidth(m.f)
+			}
+		}
+		if adv != nil || len(in.skip) > 0 {
+			wid = 0
+			k := 0
+			for i := 0; i <= l; i++ {
+				for ; k < len(in.skip) && in.skip[k].at == i; k++ {
+					c := cacheinfo{value: in.skip[k].r, width: uint8(repl)}
+					if adv != nil {
+						wid += adv(&c, twid+wid)
+					} else {
+						wid += repl
+					}
+				}
+				if i == l {
+					break
+				}
+				if c := &f.cache[cbuf[i]]; adv != nil {
+					wid += adv(c, twid+wid)
+				} else {
+					wid += int(c.width)
+				}
+			}
+			in.skip = in.skip[:0]
+		}
 		sf.free()
 		agefont(f)
 		twid += wid
@@ -56,6 +249,23 @@ func stringnwidth(f *Font, s string, b []byte, r []rune) int {
 	return twid
 }
 
+// errSubfontFailed stands for the error of loading a subfont that
+// measure has already failed to load.
+var errSubfontFailed = errors.New("subfont failed to load")
+
+// replwidth returns the width of f's replacement rune,
+// or 0 if the font cannot show it either.
+func replwidth(f *Font) int {
+	m := measurers.Get().(*Measurer)
+	m.f = f
+	m.norepl = true
+	wid := m.measure(string(f.replacement()), nil, nil, nil)
+	m.f = nil
+	m.norepl = false
+	measurers.Put(m)
+	return wid
+}
+
 // StringWidth returns the number of horizontal pixels that would be occupied
 // by the string if it were drawn using the font.
 func (f *Font) StringWidth(s string) int {
@@ -80,12 +290,338 @@ func (f *Font) RunesWidth(r []rune) int {
 	return stringnwidth(f, "", nil, r)
 }
 
+// StringWidthInfo returns the number of horizontal pixels that would be
+// occupied by the string if it were drawn using the font, as StringWidth
+// does, and whether any of its runes had to be measured as something
+// else: as the replacement rune, for runes the font has no glyph for
+// and invalid ones, or in the display's default font, for those whose
+// subfonts would not load. It also returns those runes, each once, an
+// invalid UTF-8 encoding as utf8.RuneError, so that callers can choose
+// some other font or flag the text.
+func (f *Font) StringWidthInfo(s string) (width int, substituted bool, badRunes []rune) {
+	f.lock()
+	defer f.unlock()
+	var subst []rune
+	m := measurers.Get().(*Measurer)
+	m.f = f
+	m.subst = &subst
+	s, _, r := visual(f, s, nil, nil)
+	width = m.measure(s, nil, r, runeadvance(f))
+	m.f = nil
+	m.subst = nil
+	measurers.Put(m)
+	seen := make(map[rune]bool)
+	for _, r := range subst {
+		if !seen[r] {
+			seen[r] = true
+			badRunes = append(badRunes, r)
+		}
+	}
+	return width, len(subst) > 0, badRunes
+}
+
+// StringWidthTabStops returns the number of horizontal pixels that would be
+// occupied by the string if it were drawn using the font starting at x0,
+// with each tab advancing to the first of the listed stops greater than the
+// current position. The stops are x positions in increasing order; past the
+// last one, further stops are spaced by the interval between the last two.
+// If stops is empty, tabs are measured like any other character.
+func (f *Font) StringWidthTabStops(s string, stops []int, x0 int) int {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	s, _, r := visual(f, s, nil, nil)
+	return measure(f, s, nil, r, func(c *cacheinfo, x int) int {
+		if c.value == '\t' && len(stops) > 0 {
+			x += x0
+			return tabstop(stops, x) - x
+		}
+		if next != nil {
+			return next(c, x)
+		}
+		return int(c.width)
+	})
+}
+
+// tabstop returns the first tab stop greater than x.
+func tabstop(stops []int, x int) int {
+	for _, stop := range stops {
+		if stop > x {
+			return stop
+		}
+	}
+	last := stops[len(stops)-1]
+	iv := last
+	if len(stops) > 1 {
+		iv -= stops[len(stops)-2]
+	}
+	if iv <= 0 {
+		return x
+	}
+	return last + ((x-last)/iv+1)*iv
+}
+
+// OffsetOptions controls the measurement done by RuneOffsets.
+type OffsetOptions struct {
+	// TabWidth, if positive, makes tabs advance to the next
+	// multiple of TabWidth pixels instead of using the tab glyph.
+	TabWidth int
+
+	// TabSpaces, if positive and TabWidth is not, makes tabs advance
+	// to the next multiple of TabSpaces times the width of a space.
+	TabSpaces int
+}
+
+// RuneOffsets returns the horizontal pixel offset of every rune boundary
+// in the string if it were drawn using the font. There are one more offsets
+// than runes: element i is the x position before rune i, so the first
+// element is 0 and the last one is the width of the whole string.
+// The options may be nil.
+func (f *Font) RuneOffsets(s string, opt *OffsetOptions) []int {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	runes := make([]rune, 0, len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = f.replacement() // as measure reads it
+		}
+		runes = append(runes, r)
+		i += size
+	}
+	tabw := 0
+	if opt != nil {
+		tabw = opt.TabWidth
+		if tabw <= 0 && opt.TabSpaces > 0 {
+			tabw = opt.TabSpaces * spacewidth(f)
+		}
+	}
+	offs := make([]int, len(runes)+1)
+	i := 0
+	wid := measure(f, s, nil, nil, func(c *cacheinfo, x int) int {
+		// Runes the font cannot show at all are not measured;
+		// give them no width.
+		for i < len(runes) && runes[i] != c.value {
+			i++
+			offs[i] = x
+		}
+		var w int
+		switch {
+		case c.value == '\t' && tabw > 0:
+			w = tabstop([]int{tabw}, x) - x
+		case next != nil:
+			w = next(c, x)
+		default:
+			w = int(c.width)
+		}
+		if i < len(runes) {
+			i++
+			offs[i] = x + w
+		}
+		return w
+	})
+	for i < len(runes) {
+		i++
+		offs[i] = wid
+	}
+	return offs
+}
+
+// StringInkWidth returns the number of horizontal pixels spanned by the
+// glyph images of the string if it were drawn using the font. Unlike
+// StringWidth it leaves out the side bearings of the first and last
+// glyphs, which makes it the width to use when centering text visually.
+// If the string has no visible glyphs, it returns the advance width.
+func (f *Font) StringInkWidth(s string) int {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	ink := false
+	var min, max int
+	wid := measure(f, s, nil, nil, func(c *cacheinfo, x int) int {
+		if c.img > 0 {
+			x0 := x + int(c.left)
+			x1 := x0 + int(c.img)
+			if !ink || x0 < min {
+				min = x0
+			}
+			if !ink || x1 > max {
+				max = x1
+			}
+			ink = true
+		}
+		if next != nil {
+			return next(c, x)
+		}
+		return int(c.width)
+	})
+	if !ink {
+		return wid
+	}
+	return max - min
+}
+
+// StringClusters returns the number of horizontal pixels that would be
+// occupied by the string if it were drawn using the font, as StringWidth
+// does, together with the number of grapheme clusters, the characters
+// a user perceives, in the string. A base character with its combining
+// marks, an emoji with its modifiers or a sequence of emoji joined by
+// ZWJ, and a flag made of two regional indicators each count as one
+// cluster, whose advance is that of all its runes together. The text
+// is taken in logical order; Reorder is not called.
+func (f *Font) StringClusters(s string) (width, clusters int) {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	var cl clusterer
+	width = measure(f, s, nil, nil, func(c *cacheinfo, x int) int {
+		if cl.next(c.value) {
+			clusters++
+		}
+		if next != nil {
+			return next(c, x)
+		}
+		return int(c.width)
+	})
+	return width, clusters
+}
+
+// StringWidthScaled returns the number of horizontal pixels that would
+// be occupied by the string if it were drawn using the font scaled by
+// num/den, which must be positive. Every rune starts at its exact
+// unscaled position scaled and rounded to the nearest pixel, so the
+// rounding does not pile up along the string the way it does when each
+// advance is rounded on its own.
+func (f *Font) StringWidthScaled(s string, num, den int) int {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	s, _, r := visual(f, s, nil, nil)
+	exact, pos := 0, 0 // unscaled x times num; scaled x
+	return measure(f, s, nil, r, func(c *cacheinfo, x int) int {
+		w := int(c.width)
+		if next != nil {
+			w = next(c, exact/num)
+		}
+		exact += w * num
+		end := (2*exact + den) / (2 * den)
+		w, pos = end-pos, end
+		return w
+	})
+}
+
+// StringWidthWithCaret returns the number of horizontal pixels that would
+// be occupied by the string if it were drawn using the font with a caret
+// caretWidth pixels wide before rune caretIndex, as for a block cursor.
+// A caretIndex equal to the number of runes puts the caret at the end of
+// the string. If caretIndex is out of that range, or caretWidth is not
+// positive, there is no caret and the result is that of StringWidth.
+func (f *Font) StringWidthWithCaret(s string, caretIndex, caretWidth int) int {
+	wid := f.StringWidth(s)
+	if caretWidth > 0 && 0 <= caretIndex && caretIndex <= utf8.RuneCountInString(s) {
+		wid += caretWidth
+	}
+	return wid
+}
+
+// AverageWidth returns the mean width in pixels of the printable ASCII
+// characters in the font. It is cached until the font's generation changes.
+func (f *Font) AverageWidth() int {
+	f.lock()
+	defer f.unlock()
+	asciiwidths(f)
+	return f.avgwidth
+}
+
+// MaxWidth returns the width in pixels of the widest printable ASCII
+// character in the font. It is cached until the font's generation changes.
+func (f *Font) MaxWidth() int {
+	f.lock()
+	defer f.unlock()
+	asciiwidths(f)
+	return f.maxwidth
+}
+
+// SpaceWidth returns the width in pixels of the space character, as
+// StringWidth(" ") does, for laying out tabs and justified text. The
+// font's own width is cached until the font's generation changes.
+func (f *Font) SpaceWidth() int {
+	f.lock()
+	defer f.unlock()
+	return spacewidth(f)
+}
+
+func spacewidth(f *Font) int {
+	if w, ok := f.Widths[' ']; ok {
+		return w
+	}
+	asciiwidths(f)
+	return f.spacewidth
+}
+
+// asciiwidths fills in f.avgwidth, f.maxwidth and f.spacewidth if not
+// yet known.
+func asciiwidths(f *Font) {
+	if f.haveascii && f.asciigen == f.gen {
+		return
+	}
+	sum, n, max := 0, 0, 0
+	var buf [1]byte
+	for c := byte(' '); c <= '~'; c++ {
+		buf[0] = c
+		wid := measure(f, "", buf[:], nil, nil)
+		if wid > max {
+			max = wid
+		}
+		sum += wid
+		n++
+		if c == ' ' {
+			f.spacewidth = wid
+		}
+	}
+	f.avgwidth = (sum + n/2) / n
+	f.maxwidth = max
+	f.haveascii = true
+	f.asciigen = f.gen
+}
+
 // StringSize returns the number of horizontal and vertical pixels that would
 // be occupied by the string if it were drawn using the font.
 func (f *Font) StringSize(s string) image.Point {
 	return image.Pt(f.StringWidth(s), f.Height)
 }
 
+// StringSizeMixed is like StringSize, but for fonts made of subfonts
+// of different heights, such as a tall CJK subfont with a shorter Latin
+// one: the height returned is that of the tallest subfont that the
+// runes of the string come from. Runes that no subfont can show count
+// the height of the font, as does the empty string.
+func (f *Font) StringSizeMixed(s string) image.Point {
+	f.lock()
+	defer f.unlock()
+	next := runeadvance(f)
+	s, _, r := visual(f, s, nil, nil)
+	height := 0
+	wid := measure(f, s, nil, r, func(c *cacheinfo, x int) int {
+		h := int(c.height)
+		if h == 0 {
+			h = f.Height
+		}
+		if h > height {
+			height = h
+		}
+		if next != nil {
+			return next(c, x)
+		}
+		return int(c.width)
+	})
+	if height == 0 {
+		height = f.Height
+	}
+	return image.Pt(wid, height)
+}
+
 // ByteSize returns the number of horizontal and vertical pixels that would be
 // occupied by the byte slice if it were drawn using the font.
 func (f *Font) BytesSize(b []byte) image.Point {
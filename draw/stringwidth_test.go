@@ -0,0 +1,231 @@
+package draw
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// newTestFont returns a font with no display whose glyphs are all 10
+// pixels wide except for those listed in widths. It has glyphs for the
+// runes 1 through 0x7e; U+FFFD is among the others, which it cannot show.
+func newTestFont(widths map[rune]int) *Font {
+	const n = 0x7f
+	info := make([]Fontchar, n+1)
+	x := 0
+	for i := range info {
+		w, ok := widths[rune(i)+1]
+		if !ok {
+			w = 10
+		}
+		info[i] = Fontchar{X: x, Width: uint8(w), Left: 1, Bottom: 10}
+		x += w
+	}
+	sf := &Subfont{Name: "test", N: n, Height: 12, Ascent: 10, Info: info, Bits: &Image{Depth: 1}, ref: 1 << 20}
+	installsubfont("test", sf)
+	return &Font{
+		Name:   "test",
+		Height: 12,
+		Ascent: 10,
+		Scale:  1,
+		cache:  make([]cacheinfo, _NFCACHE+_NFLOOK),
+		subf:   make([]cachesubf, _NFSUBF),
+		age:    1,
+		sub:    []*cachefont{{min: 1, max: n - 1, subfontname: "test", name: "test"}},
+	}
+}
+
+type invalidTest struct {
+	name string
+	b    string
+	wid  int
+}
+
+// With '?' 7 pixels wide as the replacement, each bad byte adds 7.
+var invalidTests = []invalidTest{
+	{"valid", "ab", 20},
+	{"truncated", "a\xe4\xb8", 24},
+	{"truncated at start", "\xe4\xb8b", 24},
+	{"stray continuation", "a\x80b", 27},
+	{"stray continuations", "\x80\xbf", 14},
+	{"surrogate", "a\xed\xa0\x80", 31},
+	{"overlong", "\xc0\xafa", 24},
+	{"invalid start byte", "\xffa\xfe", 24},
+}
+
+func TestBytesWidthInvalid(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	f.Replacement = '?'
+	for _, test := range invalidTests {
+		if wid := f.BytesWidth([]byte(test.b)); wid != test.wid {
+			t.Errorf("%s: BytesWidth(%q) = %d, want %d", test.name, test.b, wid, test.wid)
+		}
+		if wid := f.StringWidth(test.b); wid != test.wid {
+			t.Errorf("%s: StringWidth(%q) = %d, want %d", test.name, test.b, wid, test.wid)
+		}
+		offs := f.RuneOffsets(test.b, nil)
+		if wid := offs[len(offs)-1]; wid != test.wid {
+			t.Errorf("%s: RuneOffsets(%q) ends at %d, want %d", test.name, test.b, wid, test.wid)
+		}
+	}
+}
+
+func TestRunesWidthInvalid(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	f.Replacement = '?'
+	r := []rune{'a', 0xd800, 'b', -1, 0x110000}
+	if wid, want := f.RunesWidth(r), 10+7+10+7+7; wid != want {
+		t.Errorf("RunesWidth(%U) = %d, want %d", r, wid, want)
+	}
+}
+
+func TestBytesWidthNoReplacementGlyph(t *testing.T) {
+	// The font has no glyph for U+FFFD, so bad bytes measure nothing
+	// rather than stopping the measurement.
+	f := newTestFont(nil)
+	if wid := f.BytesWidth([]byte("a\x80b")); wid != 20 {
+		t.Errorf("BytesWidth(%q) = %d, want 20", "a\x80b", wid)
+	}
+}
+
+var clusterTests = []struct {
+	s        string
+	clusters int
+}{
+	{"", 0},
+	{"abc", 3},
+	{"e\u0301", 1},                     // e with combining acute
+	{"\r\n\n", 2},                      // CR LF
+	{"\U0001f44d\U0001f3fd", 1},        // thumbs up with skin tone
+	{"\U0001f469\u200d\U0001f4bbx", 2}, // ZWJ sequence
+	{"a\u200db", 2},                    // ZWJ joins only to the letter before it
+	{"\U0001f1eb\U0001f1f7\U0001f1e9\U0001f1ea\U0001f1ee", 3}, // two flags and a lone indicator
+	{"\u1100\u1161\u11a8\uac00", 2},                           // jamo syllable, precomposed syllable
+	{"\u2764\ufe0f!", 2},                                      // variation selector
+}
+
+func TestStringClusters(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	f.Replacement = '?'
+	for _, test := range clusterTests {
+		wid, n := f.StringClusters(test.s)
+		if n != test.clusters {
+			t.Errorf("StringClusters(%+q) clusters = %d, want %d", test.s, n, test.clusters)
+		}
+		if want := f.StringWidth(test.s); wid != want {
+			t.Errorf("StringClusters(%+q) width = %d, want %d", test.s, wid, want)
+		}
+	}
+}
+
+func TestStringWidthScaled(t *testing.T) {
+	f := newTestFont(map[rune]int{'a': 7, 'b': 5})
+	tests := []struct {
+		s        string
+		num, den int
+		wid      int
+	}{
+		{"", 3, 2, 0},
+		{"aaa", 1, 1, 21},
+		{"aaa", 1, 2, 11}, // not 3 times 4
+		{"aaa", 3, 2, 32}, // not 3 times 11
+		{"bbbbbb", 2, 3, 20},
+		{"ab", 5, 4, 15},
+	}
+	for _, test := range tests {
+		if wid := f.StringWidthScaled(test.s, test.num, test.den); wid != test.wid {
+			t.Errorf("StringWidthScaled(%q, %d, %d) = %d, want %d", test.s, test.num, test.den, wid, test.wid)
+		}
+	}
+}
+
+func TestWidthsOverride(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	f.Replacement = '?'
+	f.Widths = map[rune]int{'b': 3, 0x2500: 8} // U+2500 is not in the font
+	if wid, want := f.StringWidth("ab\u2500b"), 10+3+8+3; wid != want {
+		t.Errorf("StringWidth = %d, want %d", wid, want)
+	}
+	f.Widths = nil
+	if wid, want := f.StringWidth("ab\u2500b"), 10+10+7+10; wid != want {
+		t.Errorf("StringWidth with no overrides = %d, want %d", wid, want)
+	}
+}
+
+func TestStringWidthWithCaret(t *testing.T) {
+	f := newTestFont(map[rune]int{'a': 7})
+	tests := []struct {
+		s            string
+		caret, width int
+		wid          int
+	}{
+		{"aaa", 0, 5, 26},
+		{"aaa", 1, 5, 26},
+		{"aaa", 3, 5, 26}, // at the end
+		{"", 0, 5, 5},
+		{"aaa", 1, 0, 21},
+		{"aaa", 4, 5, 21},
+		{"aaa", -1, 5, 21},
+		{"\u00e9a", 2, 5, 12}, // an index in runes, not bytes; U+00E9 is not in the font
+		{"\u00e9a", 3, 5, 7},
+	}
+	for _, test := range tests {
+		if wid := f.StringWidthWithCaret(test.s, test.caret, test.width); wid != test.wid {
+			t.Errorf("StringWidthWithCaret(%+q, %d, %d) = %d, want %d", test.s, test.caret, test.width, wid, test.wid)
+		}
+	}
+}
+
+func TestSpaceWidth(t *testing.T) {
+	f := newTestFont(map[rune]int{' ': 4})
+	if wid := f.SpaceWidth(); wid != 4 {
+		t.Errorf("SpaceWidth = %d, want 4", wid)
+	}
+	offs := f.RuneOffsets("a\tb", &OffsetOptions{TabSpaces: 4})
+	if want := []int{0, 10, 16, 26}; !reflect.DeepEqual(offs, want) {
+		t.Errorf("RuneOffsets with TabSpaces 4 = %v, want %v", offs, want)
+	}
+	f.Widths = map[rune]int{' ': 6}
+	if wid := f.SpaceWidth(); wid != 6 {
+		t.Errorf("SpaceWidth with Widths = %d, want 6", wid)
+	}
+}
+
+func TestStringSizeMixed(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	lastfont.sub.Height = 20 // a subfont taller than the font
+	f.Replacement = '?'
+	if p, want := f.StringSizeMixed("ab"), (image.Point{20, 20}); p != want {
+		t.Errorf("StringSizeMixed = %v, want %v", p, want)
+	}
+	if p, want := f.StringSize("ab"), (image.Point{20, 12}); p != want {
+		t.Errorf("StringSize = %v, want %v", p, want)
+	}
+	if p, want := f.StringSizeMixed(""), (image.Point{0, 12}); p != want {
+		t.Errorf("StringSizeMixed of the empty string = %v, want %v", p, want)
+	}
+}
+
+func TestStringWidthInfo(t *testing.T) {
+	f := newTestFont(map[rune]int{'?': 7})
+	f.Replacement = '?'
+	for _, test := range []struct {
+		s     string
+		wid   int
+		subst bool
+		bad   []rune
+	}{
+		{"", 0, false, nil},
+		{"ab", 20, false, nil},
+		{"a\u00e9b\u00e9\u4e16", 10 + 7 + 10 + 7 + 7, true, []rune{0xe9, 0x4e16}},
+		{"a\x80", 17, true, []rune{0xfffd}}, // invalid UTF-8 reads as the replacement, which the font has
+	} {
+		wid, subst, bad := f.StringWidthInfo(test.s)
+		if wid != test.wid || subst != test.subst || !reflect.DeepEqual(bad, test.bad) {
+			t.Errorf("StringWidthInfo(%q) = %d, %v, %U, want %d, %v, %U", test.s, wid, subst, bad, test.wid, test.subst, test.bad)
+		}
+		if w := f.StringWidth(test.s); w != wid {
+			t.Errorf("StringWidth(%q) = %d, StringWidthInfo gives %d", test.s, w, wid)
+		}
+	}
+}